@@ -0,0 +1,148 @@
+package mptrie
+
+import (
+	"bytes"
+	"fmt"
+)
+
+type cachedNode struct {
+	buf      []byte
+	children [][]byte // The hashes of the children of the node.
+	refs     int      // References from cached parents and from Reference.
+}
+
+// Database is a NodeStore which holds committed nodes in memory, in front of another
+// NodeStore. Nodes are reference counted: each cached parent holds a reference to its
+// children, and roots are referenced explicitly. Dereferencing a root removes the nodes which
+// are no longer referenced, and nodes are only written to the underlying store by Flush.
+// Committing a trie forked from a root which has since been dereferenced, such as a clone
+// kept for a reorg, writes the nodes it shares with that root again.
+type Database struct {
+	store NodeStore
+	nodes map[string]*cachedNode
+	roots [][]byte // The roots retained by Retain, oldest first.
+	keep  int
+}
+
+// NewDatabase returns a database in front of store; Retain keeps the last keep roots.
+func NewDatabase(store NodeStore, keep int) *Database {
+	return &Database{
+		store: store,
+		nodes: map[string]*cachedNode{},
+		keep:  keep,
+	}
+}
+
+func (db *Database) Get(hash []byte) ([]byte, error) {
+	if cn, ok := db.nodes[string(hash)]; ok {
+		return cn.buf, nil
+	}
+	return db.store.Get(hash)
+}
+
+func (db *Database) Has(hash []byte) (bool, error) {
+	if _, ok := db.nodes[string(hash)]; ok {
+		return true, nil
+	}
+	return hasNode(db.store, hash)
+}
+
+func (db *Database) Put(hash, buf []byte) error {
+	if _, ok := db.nodes[string(hash)]; ok {
+		return nil
+	}
+
+	n, err := decodeNode(buf)
+	if err != nil {
+		return err
+	}
+	cn := &cachedNode{
+		buf:      append([]byte(nil), buf...),
+		children: appendChildHashes(nil, n),
+	}
+	for _, child := range cn.children {
+		if ccn, ok := db.nodes[string(child)]; ok {
+			ccn.refs += 1
+		}
+	}
+
+	db.nodes[string(hash)] = cn
+	return nil
+}
+
+// Reference adds a reference to root, which must have been committed to the database.
+func (db *Database) Reference(root []byte) {
+	if cn, ok := db.nodes[string(root)]; ok {
+		cn.refs += 1
+	}
+}
+
+// Dereference removes a reference to root, and removes the nodes which are no longer
+// referenced.
+func (db *Database) Dereference(root []byte) {
+	cn, ok := db.nodes[string(root)]
+	if !ok {
+		return
+	}
+
+	cn.refs -= 1
+	if cn.refs > 0 {
+		return
+	}
+	delete(db.nodes, string(root))
+	for _, child := range cn.children {
+		db.Dereference(child)
+	}
+}
+
+// Retain references root, and dereferences the oldest retained root once more than keep roots
+// are retained.
+func (db *Database) Retain(root []byte) {
+	if bytes.Equal(root, emptyHash) {
+		return
+	}
+
+	db.Reference(root)
+	db.roots = append(db.roots, append([]byte(nil), root...))
+	if len(db.roots) > db.keep {
+		db.Dereference(db.roots[0])
+		db.roots = db.roots[1:]
+	}
+}
+
+// Flush writes the cached nodes reachable from root to the underlying store, children before
+// parents, and then removes them from the database.
+func (db *Database) Flush(root []byte) error {
+	cn, ok := db.nodes[string(root)]
+	if !ok {
+		if bytes.Equal(root, emptyHash) {
+			return nil
+		}
+		_, err := db.store.Get(root)
+		if err == ErrNotFound {
+			return &MissingNodeError{Hash: root}
+		}
+		return err
+	}
+
+	for _, child := range cn.children {
+		if _, ok := db.nodes[string(child)]; ok {
+			err := db.Flush(child)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	err := db.store.Put(root, cn.buf)
+	if err != nil {
+		return fmt.Errorf("mptrie: flushing node %x: %w", root, err)
+	}
+	delete(db.nodes, string(root))
+	return nil
+}
+
+// Len returns the number of nodes held in memory.
+func (db *Database) Len() int {
+	return len(db.nodes)
+}
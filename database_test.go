@@ -0,0 +1,112 @@
+package mptrie
+
+import "testing"
+
+func TestDatabase(t *testing.T) {
+	store := NewMemoryStore()
+	db := NewDatabase(store, 2)
+
+	var roots [][]byte
+	mpt := New()
+	for v := 0; v < 4; v++ {
+		for i := v * 50; i < v*50+200; i++ {
+			err := mpt.Put(testKey(i), append(testValue(i), byte(v)))
+			if err != nil {
+				t.Fatalf("Put(%d) failed with %s", i, err)
+			}
+		}
+		root, err := mpt.Commit(db)
+		if err != nil {
+			t.Fatalf("Commit() failed with %s", err)
+		}
+		roots = append(roots, root)
+		db.Retain(root)
+
+		if store.Len() != 0 {
+			t.Errorf("Commit(): got %d nodes in the store, want 0", store.Len())
+		}
+	}
+
+	checkRoot(t, db, roots[0], false)
+	checkRoot(t, db, roots[1], false)
+	checkRoot(t, db, roots[2], true)
+	checkRoot(t, db, roots[3], true)
+
+	want, err := markNodes(db, roots[3:])
+	if err != nil {
+		t.Fatalf("markNodes() failed with %s", err)
+	}
+	db.Dereference(roots[2])
+	if db.Len() != len(want) {
+		t.Errorf("Dereference(): got %d nodes, want %d", db.Len(), len(want))
+	}
+
+	err = db.Flush(roots[3])
+	if err != nil {
+		t.Fatalf("Flush() failed with %s", err)
+	}
+	if db.Len() != 0 {
+		t.Errorf("Flush(): got %d nodes in the database, want 0", db.Len())
+	}
+	if store.Len() != len(want) {
+		t.Errorf("Flush(): got %d nodes in the store, want %d", store.Len(), len(want))
+	}
+	checkRoot(t, db, roots[3], true)
+	checkRoot(t, store, roots[3], true)
+
+	db.Dereference(roots[3])
+	checkRoot(t, store, roots[3], true)
+
+	err = db.Flush(roots[0])
+	if _, ok := err.(*MissingNodeError); !ok {
+		t.Errorf("Flush(%x): got %v, want missing node", roots[0], err)
+	}
+}
+
+func TestDatabaseFork(t *testing.T) {
+	store := NewMemoryStore()
+	db := NewDatabase(store, 1)
+
+	mpt := New()
+	for i := 0; i < 200; i++ {
+		err := mpt.Put(testKey(i), testValue(i))
+		if err != nil {
+			t.Fatalf("Put(%d) failed with %s", i, err)
+		}
+	}
+	root1, err := mpt.Commit(db)
+	if err != nil {
+		t.Fatalf("Commit() failed with %s", err)
+	}
+	db.Retain(root1)
+
+	fork := mpt.Clone()
+	for i := 0; i < 100; i++ {
+		err := mpt.Put(testKey(i), append(testValue(i), 0xFF))
+		if err != nil {
+			t.Fatalf("Put(%d) failed with %s", i, err)
+		}
+	}
+	root2, err := mpt.Commit(db)
+	if err != nil {
+		t.Fatalf("Commit() failed with %s", err)
+	}
+	db.Retain(root2)
+	checkRoot(t, db, root1, false)
+
+	// The nodes which the fork shared only with root1 are gone, so they are written again.
+	err = fork.Put(testKey(150), []byte("fork"))
+	if err != nil {
+		t.Fatalf("Put(150) failed with %s", err)
+	}
+	root3, err := fork.Commit(db)
+	if err != nil {
+		t.Fatalf("Commit(fork) failed with %s", err)
+	}
+	db.Retain(root3)
+	checkRoot(t, db, root3, true)
+	checkRoot(t, db, root2, false)
+	if store.Len() != 0 {
+		t.Errorf("Commit(fork): got %d nodes in the store, want 0", store.Len())
+	}
+}
@@ -0,0 +1,37 @@
+package mptrie
+
+// TrieHasher computes the root hash of keys and values; both MPTrie and StackTrie implement
+// it.
+type TrieHasher interface {
+	Put(key, val []byte) error
+	Hash() []byte
+}
+
+// DeriveSha returns the root hash of a trie containing items, each of which is already
+// encoded, keyed by the RLP encoding of its index; this is how the transaction, receipt and
+// withdrawal roots of a block are computed.
+func DeriveSha(items [][]byte, th TrieHasher) ([]byte, error) {
+	// The items are put in the order of their keys so that th can be a StackTrie: indexes 1
+	// through 127 encode as a single byte, which sorts before 0 (0x80), which sorts before
+	// indexes 128 and greater.
+	for i := 1; i < len(items) && i <= 0x7F; i++ {
+		err := th.Put(encodeScalar(uint64(i)), items[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(items) > 0 {
+		err := th.Put(encodeScalar(0), items[0])
+		if err != nil {
+			return nil, err
+		}
+	}
+	for i := 0x80; i < len(items); i++ {
+		err := th.Put(encodeScalar(uint64(i)), items[i])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return th.Hash(), nil
+}
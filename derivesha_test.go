@@ -0,0 +1,67 @@
+package mptrie
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestEncodeScalar(t *testing.T) {
+	cases := []struct {
+		u   uint64
+		buf []byte
+	}{
+		{u: 0, buf: []byte{0x80}},
+		{u: 1, buf: []byte{0x01}},
+		{u: 0x7F, buf: []byte{0x7F}},
+		{u: 0x80, buf: []byte{0x81, 0x80}},
+		{u: 0xFF, buf: []byte{0x81, 0xFF}},
+		{u: 0x100, buf: []byte{0x82, 0x01, 0x00}},
+		{u: 0x12345, buf: []byte{0x83, 0x01, 0x23, 0x45}},
+	}
+
+	for _, c := range cases {
+		buf := encodeScalar(c.u)
+		if !bytes.Equal(buf, c.buf) {
+			t.Errorf("encodeScalar(%d): got %v, want %v", c.u, buf, c.buf)
+		}
+	}
+}
+
+func TestDeriveSha(t *testing.T) {
+	h, err := DeriveSha(nil, NewStackTrie())
+	if err != nil {
+		t.Fatalf("DeriveSha() failed with %s", err)
+	} else if !bytes.Equal(h, emptyHash) {
+		t.Errorf("DeriveSha(): got %x, want %x", h, emptyHash)
+	}
+
+	for _, n := range []int{1, 2, 127, 128, 129, 300, 1000} {
+		var items [][]byte
+		for i := 0; i < n; i++ {
+			items = append(items, encodeBytes(nil, bytes.Repeat([]byte{byte(i)}, 1+i%60)))
+		}
+
+		h, err := DeriveSha(items, NewStackTrie())
+		if err != nil {
+			t.Fatalf("DeriveSha(%d) failed with %s", n, err)
+		}
+
+		mpt := New()
+		for i, item := range items {
+			err := mpt.Put(encodeScalar(uint64(i)), item)
+			if err != nil {
+				t.Fatalf("Put(%d) failed with %s", i, err)
+			}
+		}
+		if !bytes.Equal(h, mpt.Hash()) {
+			t.Errorf("DeriveSha(%d): got %x, want %x", n, h, mpt.Hash())
+		}
+
+		h, err = DeriveSha(items, New())
+		if err != nil {
+			t.Fatalf("DeriveSha(%d) failed with %s", n, err)
+		} else if !bytes.Equal(h, mpt.Hash()) {
+			t.Errorf("DeriveSha(%d): got %x, want %x", n, h, mpt.Hash())
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package mptrie
+
+import (
+	"bytes"
+	"fmt"
+)
+
+type diffFrame struct {
+	a, b node
+	path nibbleKey
+}
+
+// DiffIterator returns the keys whose values differ between two tries in lexicographic key
+// order. Subtrees which are the same in both tries are skipped without being loaded.
+type DiffIterator struct {
+	a, b     *MPTrie
+	stack    []diffFrame
+	it       *Iterator // Iterates a subtree only in a or only in b.
+	itOld    bool      // The subtree being iterated is only in a.
+	key      []byte
+	oldValue []byte
+	newValue []byte
+	err      error
+}
+
+// Diff returns an iterator over the keys which were added, changed or deleted going from a to
+// b. Neither trie may be changed while the iterator is in use.
+func Diff(a, b *MPTrie) *DiffIterator {
+	di := &DiffIterator{
+		a: a,
+		b: b,
+	}
+	di.push(a.root, b.root, nil)
+	return di
+}
+
+// DiffRoots returns an iterator over the keys which were added, changed or deleted going from
+// the trie with root hash a to the trie with root hash b; both are loaded from store.
+func DiffRoots(store NodeStore, a, b []byte) (*DiffIterator, error) {
+	mptA, err := Open(a, store)
+	if err != nil {
+		return nil, err
+	}
+	mptB, err := Open(b, store)
+	if err != nil {
+		return nil, err
+	}
+	return Diff(mptA, mptB), nil
+}
+
+func (di *DiffIterator) push(a, b node, path nibbleKey) {
+	if a == nil && b == nil {
+		return
+	} else if a != nil && b != nil && bytes.Equal(a.hash(false), b.hash(false)) {
+		return
+	}
+	di.stack = append(di.stack, diffFrame{a: a, b: b, path: path})
+}
+
+// expandNode returns n as a branch so that it can be compared with a different kind of node
+// at the same path. The returned branch, and any nodes it creates, are never part of a trie.
+func expandNode(n node) *branchNode {
+	if branch, ok := n.(*branchNode); ok {
+		return branch
+	}
+
+	branch := &branchNode{generation: decodedGeneration}
+	if extension, ok := n.(*extensionNode); ok {
+		if len(extension.subKey) == 1 {
+			branch.children[extension.subKey[0]] = extension.child
+		} else {
+			branch.children[extension.subKey[0]] = &extensionNode{
+				subKey:     extension.subKey[1:],
+				child:      extension.child,
+				generation: decodedGeneration,
+			}
+		}
+	} else if leaf, ok := n.(*leafNode); ok {
+		if len(leaf.suffixKey) == 0 {
+			branch.value = leaf.value
+		} else {
+			branch.children[leaf.suffixKey[0]] = &leafNode{
+				suffixKey:  leaf.suffixKey[1:],
+				value:      leaf.value,
+				generation: decodedGeneration,
+			}
+		}
+	} else {
+		panic(fmt.Sprintf("unexpected mptrie node: %#v", n))
+	}
+	return branch
+}
+
+func (di *DiffIterator) fail(err error) bool {
+	di.err = err
+	di.stack = nil
+	di.it = nil
+	return false
+}
+
+// Next moves the iterator to the next key which differs, and returns false when there are no
+// more keys or an error was encountered.
+func (di *DiffIterator) Next() bool {
+	di.key = nil
+	di.oldValue = nil
+	di.newValue = nil
+
+	for {
+		if di.it != nil {
+			if di.it.Next() {
+				di.key = di.it.Key()
+				if di.itOld {
+					di.oldValue = di.it.Value()
+				} else {
+					di.newValue = di.it.Value()
+				}
+				return true
+			} else if di.it.Err() != nil {
+				return di.fail(di.it.Err())
+			}
+			di.it = nil
+		}
+
+		if len(di.stack) == 0 {
+			return false
+		}
+		f := di.stack[len(di.stack)-1]
+		di.stack = di.stack[:len(di.stack)-1]
+
+		if f.a == nil || f.b == nil {
+			mpt, n := di.b, f.b
+			if f.a != nil {
+				mpt, n = di.a, f.a
+			}
+			di.it = &Iterator{
+				mpt:   mpt,
+				stack: []iteratorFrame{{n: n, path: f.path, ci: -1}},
+			}
+			di.itOld = f.a != nil
+			continue
+		}
+
+		err := di.a.resolveChild(&f.a, f.path)
+		if err != nil {
+			return di.fail(err)
+		}
+		err = di.b.resolveChild(&f.b, f.path)
+		if err != nil {
+			return di.fail(err)
+		}
+
+		if leafA, ok := f.a.(*leafNode); ok {
+			if leafB, ok := f.b.(*leafNode); ok && bytes.Equal(leafA.suffixKey, leafB.suffixKey) {
+				di.key = nibbleKeyToKey(joinNibbleKeys(f.path, leafA.suffixKey))
+				di.oldValue = leafA.value
+				di.newValue = leafB.value
+				return true
+			}
+		} else if extA, ok := f.a.(*extensionNode); ok {
+			if extB, ok := f.b.(*extensionNode); ok && bytes.Equal(extA.subKey, extB.subKey) {
+				di.push(extA.child, extB.child, joinNibbleKeys(f.path, extA.subKey))
+				continue
+			}
+		}
+
+		// The nodes are different kinds or have different keys: compare them as branches.
+		branchA := expandNode(f.a)
+		branchB := expandNode(f.b)
+		for ci := len(branchA.children) - 1; ci >= 0; ci -= 1 {
+			di.push(branchA.children[ci], branchB.children[ci],
+				joinNibbleKeys(f.path, nibbleKey{byte(ci)}))
+		}
+		if !bytes.Equal(branchA.value, branchB.value) {
+			di.key = nibbleKeyToKey(f.path)
+			di.oldValue = branchA.value
+			di.newValue = branchB.value
+			return true
+		}
+	}
+}
+
+// Key returns the current key.
+func (di *DiffIterator) Key() []byte {
+	return di.key
+}
+
+// OldValue returns the value of the current key in the first trie; it is nil if the key was
+// added.
+func (di *DiffIterator) OldValue() []byte {
+	return di.oldValue
+}
+
+// NewValue returns the value of the current key in the second trie; it is nil if the key was
+// deleted.
+func (di *DiffIterator) NewValue() []byte {
+	return di.newValue
+}
+
+// Err returns the error, if any, that stopped the iterator.
+func (di *DiffIterator) Err() error {
+	return di.err
+}
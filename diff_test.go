@@ -0,0 +1,176 @@
+package mptrie
+
+import (
+	"bytes"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+type diffEntry struct {
+	key, oldValue, newValue []byte
+}
+
+func wantDiff(a, b map[string][]byte) []diffEntry {
+	var entries []diffEntry
+	for k, v := range a {
+		if bv, ok := b[k]; !ok || !bytes.Equal(v, bv) {
+			entries = append(entries, diffEntry{key: []byte(k), oldValue: v, newValue: bv})
+		}
+	}
+	for k, v := range b {
+		if _, ok := a[k]; !ok {
+			entries = append(entries, diffEntry{key: []byte(k), newValue: v})
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return bytes.Compare(entries[i].key, entries[j].key) < 0
+	})
+	return entries
+}
+
+func checkDiff(t *testing.T, di *DiffIterator, want []diffEntry) {
+	t.Helper()
+
+	for _, e := range want {
+		if !di.Next() {
+			t.Fatalf("Next(): got false, want key %v (err %v)", e.key, di.Err())
+		}
+		if !bytes.Equal(di.Key(), e.key) {
+			t.Fatalf("Key(): got %v, want %v", di.Key(), e.key)
+		}
+		if !bytes.Equal(di.OldValue(), e.oldValue) || (di.OldValue() == nil) != (e.oldValue == nil) {
+			t.Errorf("OldValue(%v): got %v, want %v", e.key, di.OldValue(), e.oldValue)
+		}
+		if !bytes.Equal(di.NewValue(), e.newValue) || (di.NewValue() == nil) != (e.newValue == nil) {
+			t.Errorf("NewValue(%v): got %v, want %v", e.key, di.NewValue(), e.newValue)
+		}
+	}
+
+	if di.Next() {
+		t.Errorf("Next(): got key %v, want false", di.Key())
+	}
+	if di.Err() != nil {
+		t.Errorf("Err(): got %s", di.Err())
+	}
+}
+
+type getCountingStore struct {
+	*MemoryStore
+	gets int
+}
+
+func (gcs *getCountingStore) Get(hash []byte) ([]byte, error) {
+	gcs.gets += 1
+	return gcs.MemoryStore.Get(hash)
+}
+
+func TestDiff(t *testing.T) {
+	checkDiff(t, Diff(New(), New()), nil)
+
+	r := rand.New(rand.NewSource(1))
+	a := New()
+	am := map[string][]byte{}
+	for i := 0; i < 500; i++ {
+		key := testKey(i)[:1+r.Intn(4)]
+		err := a.Put(key, testValue(i))
+		if err != nil {
+			t.Fatalf("Put(%v) failed with %s", key, err)
+		}
+		am[string(key)] = testValue(i)
+	}
+
+	checkDiff(t, Diff(a, a), nil)
+	checkDiff(t, Diff(New(), a), wantDiff(nil, am))
+	checkDiff(t, Diff(a, New()), wantDiff(am, nil))
+
+	for n := 1; n < 200; n *= 3 {
+		b := a.Clone()
+		bm := map[string][]byte{}
+		for k, v := range am {
+			bm[k] = v
+		}
+
+		for i := 0; i < n; i++ {
+			key := testKey(r.Intn(1000))[:1+r.Intn(4)]
+			if _, ok := bm[string(key)]; ok && r.Intn(2) == 0 {
+				err := b.Delete(key)
+				if err != nil {
+					t.Fatalf("Delete(%v) failed with %s", key, err)
+				}
+				delete(bm, string(key))
+			} else {
+				val := testValue(r.Intn(1000))
+				err := b.Put(key, val)
+				if err != nil {
+					t.Fatalf("Put(%v) failed with %s", key, err)
+				}
+				bm[string(key)] = val
+			}
+		}
+
+		checkDiff(t, Diff(a, b), wantDiff(am, bm))
+		checkDiff(t, Diff(b, a), wantDiff(bm, am))
+	}
+}
+
+func TestDiffRoots(t *testing.T) {
+	store := &getCountingStore{MemoryStore: NewMemoryStore()}
+
+	a := New()
+	for i := 0; i < 1000; i++ {
+		err := a.Put(testKey(i), testValue(i))
+		if err != nil {
+			t.Fatalf("Put(%d) failed with %s", i, err)
+		}
+	}
+	b := a.Clone()
+	err := b.Put(testKey(7), []byte("changed"))
+	if err != nil {
+		t.Fatalf("Put(7) failed with %s", err)
+	}
+	err = b.Delete(testKey(8))
+	if err != nil {
+		t.Fatalf("Delete(8) failed with %s", err)
+	}
+
+	rootA, err := a.Commit(store)
+	if err != nil {
+		t.Fatalf("Commit() failed with %s", err)
+	}
+	rootB, err := b.Commit(store)
+	if err != nil {
+		t.Fatalf("Commit() failed with %s", err)
+	}
+
+	want := []diffEntry{
+		{key: testKey(7), oldValue: testValue(7), newValue: []byte("changed")},
+		{key: testKey(8), oldValue: testValue(8)},
+	}
+	if bytes.Compare(testKey(8), testKey(7)) < 0 {
+		want[0], want[1] = want[1], want[0]
+	}
+
+	di, err := DiffRoots(store, rootA, rootB)
+	if err != nil {
+		t.Fatalf("DiffRoots() failed with %s", err)
+	}
+	checkDiff(t, di, want)
+
+	// Only the nodes on the paths to the changed keys should have been loaded.
+	if store.gets > 20 {
+		t.Errorf("DiffRoots(): got %d gets, want at most 20 of %d nodes", store.gets,
+			store.Len())
+	}
+
+	store.MemoryStore = NewMemoryStore()
+	di, err = DiffRoots(store, rootA, rootB)
+	if err != nil {
+		t.Fatalf("DiffRoots() failed with %s", err)
+	}
+	if di.Next() {
+		t.Errorf("Next(): got key %v, want false", di.Key())
+	} else if _, ok := di.Err().(*MissingNodeError); !ok {
+		t.Errorf("Err(): got %v, want MissingNodeError", di.Err())
+	}
+}
@@ -0,0 +1,156 @@
+package mptrie
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+const maxDotValue = 16
+
+// DotOptions control how WriteDot writes a trie.
+type DotOptions struct {
+	MaxDepth  int    // Nodes more than MaxDepth below the root are not written; 0 for no limit.
+	Highlight []byte // Highlight the path to this key; nil for no highlighting.
+}
+
+type dotWriter struct {
+	mpt       *MPTrie
+	w         io.Writer
+	opts      DotOptions
+	highlight nibbleKey
+	ids       int
+	err       error
+}
+
+// WriteDot writes the trie to w as a Graphviz DOT graph, loading nodes from its store as
+// needed.
+func (mpt *MPTrie) WriteDot(w io.Writer, opts DotOptions) error {
+	dw := &dotWriter{
+		mpt:  mpt,
+		w:    w,
+		opts: opts,
+	}
+	if opts.Highlight != nil {
+		dw.highlight = keyToNibbleKey(opts.Highlight)
+	}
+
+	dw.printf("digraph mptrie {\n")
+	dw.printf("  node [shape=box, fontname=\"monospace\"];\n")
+	if mpt.root != nil {
+		dw.writeNode(mpt.root, nil, 0)
+	}
+	dw.printf("}\n")
+	return dw.err
+}
+
+func (dw *dotWriter) printf(format string, args ...interface{}) {
+	if dw.err == nil {
+		_, dw.err = fmt.Fprintf(dw.w, format, args...)
+	}
+}
+
+func (dw *dotWriter) highlighted(path nibbleKey) bool {
+	return dw.highlight != nil && len(path) <= len(dw.highlight) &&
+		bytes.Equal(path, dw.highlight[:len(path)])
+}
+
+func (dw *dotWriter) style(path nibbleKey) string {
+	if dw.highlighted(path) {
+		return ", color=red, penwidth=2"
+	}
+	return ""
+}
+
+func nibbleString(nk nibbleKey) string {
+	var sb strings.Builder
+	for _, n := range nk {
+		sb.WriteByte("0123456789abcdef"[n])
+	}
+	return sb.String()
+}
+
+func valueString(val []byte) string {
+	s := val
+	if len(s) > maxDotValue {
+		s = s[:maxDotValue]
+	}
+
+	var str string
+	if bytes.IndexFunc(s, func(r rune) bool { return r < ' ' || r > '~' }) < 0 {
+		str = strconv.Quote(string(s))
+	} else {
+		str = fmt.Sprintf("%x", s)
+	}
+	if len(s) < len(val) {
+		str += "..."
+	}
+	return str
+}
+
+// writeNode writes n, found at path, and its children, and returns the id of its vertex.
+func (dw *dotWriter) writeNode(n node, path nibbleKey, depth int) string {
+	id := fmt.Sprintf("n%d", dw.ids)
+	dw.ids += 1
+
+	if dw.opts.MaxDepth > 0 && depth > dw.opts.MaxDepth {
+		dw.printf("  %s [label=\"...\", shape=none];\n", id)
+		return id
+	}
+
+	if hn, ok := n.(hashNode); ok {
+		var err error
+		n, err = dw.mpt.resolve(hn, path)
+		if err != nil {
+			if dw.err == nil {
+				dw.err = err
+			}
+			return id
+		}
+	}
+
+	hash := "inline"
+	if depth == 0 {
+		hash = fmt.Sprintf("%x", n.hash(true)[:4])
+	} else if ref := n.hash(false); len(ref) >= 32 {
+		hash = fmt.Sprintf("%x", ref[1:5])
+	}
+
+	if branch, ok := n.(*branchNode); ok {
+		label := fmt.Sprintf("branch\npath: %s\nhash: %s", nibbleString(path), hash)
+		if branch.value != nil {
+			label += "\nvalue: " + valueString(branch.value)
+		}
+		dw.printf("  %s [label=%s%s];\n", id, strconv.Quote(label), dw.style(path))
+
+		for ci, child := range branch.children {
+			if child == nil {
+				continue
+			}
+
+			cp := joinNibbleKeys(path, nibbleKey{byte(ci)})
+			cid := dw.writeNode(child, cp, depth+1)
+			dw.printf("  %s -> %s [label=\"%x\"%s];\n", id, cid, ci, dw.style(cp))
+		}
+	} else if extension, ok := n.(*extensionNode); ok {
+		label := fmt.Sprintf("extension %s\npath: %s\nhash: %s",
+			nibbleString(extension.subKey), nibbleString(path), hash)
+		dw.printf("  %s [label=%s%s];\n", id, strconv.Quote(label), dw.style(path))
+
+		cp := joinNibbleKeys(path, extension.subKey)
+		cid := dw.writeNode(extension.child, cp, depth+1)
+		dw.printf("  %s -> %s [label=\"%s\"%s];\n", id, cid, nibbleString(extension.subKey),
+			dw.style(cp))
+	} else if leaf, ok := n.(*leafNode); ok {
+		label := fmt.Sprintf("leaf %s\npath: %s\nhash: %s\nvalue: %s",
+			nibbleString(leaf.suffixKey), nibbleString(path), hash, valueString(leaf.value))
+		dw.printf("  %s [label=%s%s];\n", id, strconv.Quote(label),
+			dw.style(joinNibbleKeys(path, leaf.suffixKey)))
+	} else {
+		panic(fmt.Sprintf("unexpected mptrie node: %#v", n))
+	}
+
+	return id
+}
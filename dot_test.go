@@ -0,0 +1,83 @@
+package mptrie
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWriteDot(t *testing.T) {
+	mpt := New()
+	for _, kv := range [][2]string{
+		{"doe", "reindeer"},
+		{"dog", "puppy"},
+		{"dogglesworth", "cat"},
+	} {
+		err := mpt.Put([]byte(kv[0]), []byte(kv[1]))
+		if err != nil {
+			t.Fatalf("Put(%s) failed with %s", kv[0], err)
+		}
+	}
+
+	cases := []struct {
+		opts     DotOptions
+		contains []string
+		vertices int
+		red      int
+	}{
+		{
+			contains: []string{
+				`n0 [label="extension 646f6\npath: \nhash: 8aad789d"];`,
+				`n2 [label="leaf \npath: 646f65\nhash: inline\nvalue: \"reindeer\""];`,
+				`n1 -> n3 [label="7"];`,
+				`value: \"puppy\"`,
+			},
+			vertices: 5,
+		},
+		{
+			opts: DotOptions{Highlight: []byte("dog")},
+			contains: []string{
+				`n1 -> n3 [label="7", color=red, penwidth=2];`,
+				`n1 -> n2 [label="5"];`,
+			},
+			vertices: 5,
+			red:      5,
+		},
+		{
+			opts:     DotOptions{MaxDepth: 1},
+			contains: []string{`n2 [label="...", shape=none];`},
+			vertices: 4,
+		},
+	}
+
+	for _, c := range cases {
+		var sb strings.Builder
+		err := mpt.WriteDot(&sb, c.opts)
+		if err != nil {
+			t.Fatalf("WriteDot(%v) failed with %s", c.opts, err)
+		}
+		s := sb.String()
+		if !strings.HasPrefix(s, "digraph mptrie {\n") || !strings.HasSuffix(s, "}\n") {
+			t.Errorf("WriteDot(%v): got %s, want a digraph", c.opts, s)
+		}
+		for _, str := range c.contains {
+			if !strings.Contains(s, str) {
+				t.Errorf("WriteDot(%v): got %s, want %s", c.opts, s, str)
+			}
+		}
+		if n := strings.Count(s, "[label=") - strings.Count(s, "->"); n != c.vertices {
+			t.Errorf("WriteDot(%v): got %d vertices, want %d", c.opts, n, c.vertices)
+		}
+		if n := strings.Count(s, "color=red"); n != c.red {
+			t.Errorf("WriteDot(%v): got %d highlighted, want %d", c.opts, n, c.red)
+		}
+	}
+
+	mpt, err := Open(mpt.Hash(), NewMemoryStore())
+	if err != nil {
+		t.Fatalf("Open() failed with %s", err)
+	}
+	err = mpt.WriteDot(&strings.Builder{}, DotOptions{})
+	if _, ok := err.(*MissingNodeError); !ok {
+		t.Errorf("WriteDot(): got %v, want MissingNodeError", err)
+	}
+}
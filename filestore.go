@@ -0,0 +1,337 @@
+package mptrie
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// A FileStore record is the length of the node (4 bytes), the hash of the node (32 bytes),
+// the node, and a CRC-32C of the hash and the node (4 bytes).
+const (
+	recordHeaderSize  = 4 + 32
+	recordTrailerSize = 4
+	maxRecordNode     = 1 << 24
+)
+
+var (
+	crcTable = crc32.MakeTable(crc32.Castagnoli)
+)
+
+type fileLocation struct {
+	off int64 // Offset of the node in the file.
+	len int
+}
+
+// FileStore is a NodeStore which appends nodes to a log file. An index from hash to location
+// in the file is kept in memory and rebuilt when the file is opened. Sync records the size of
+// the log in a second file once the log is on stable storage. When the file is opened, a bad
+// record before that size is an error; after it, the records were torn by a crash, so the log
+// is truncated at the first bad record.
+type FileStore struct {
+	name   string
+	f      *os.File
+	index  map[string]fileLocation
+	size   int64
+	synced int64 // The size of the log at the last Sync.
+}
+
+func OpenFileStore(name string) (*FileStore, error) {
+	// A compaction which did not finish leaves behind a file which is no longer needed.
+	err := os.Remove(compactName(name))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	fs := &FileStore{
+		name:  name,
+		f:     f,
+		index: map[string]fileLocation{},
+	}
+	fs.synced, err = readSynced(name)
+	if err == nil {
+		err = fs.scan()
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return fs, nil
+}
+
+func (fs *FileStore) scan() error {
+	fi, err := fs.f.Stat()
+	if err != nil {
+		return err
+	}
+
+	r := bufio.NewReader(fs.f)
+	hdr := make([]byte, recordHeaderSize)
+	var off int64
+	for off < fi.Size() {
+		end, ok, err := fs.scanRecord(r, hdr, off)
+		if err != nil {
+			return err
+		} else if !ok {
+			// Records after the last Sync might not have reached the disk in order, so
+			// everything from the first bad one is discarded; anything before is corruption.
+			if off < fs.synced {
+				return fmt.Errorf("mptrie: %s: bad record at offset %d", fs.name, off)
+			}
+			break
+		}
+		off = end
+	}
+	if off < fs.synced {
+		return fmt.Errorf("mptrie: %s: %d bytes were synced, but only %d remain", fs.name,
+			fs.synced, off)
+	}
+
+	if fi.Size() > off {
+		err = fs.f.Truncate(off)
+		if err != nil {
+			return err
+		}
+	}
+	fs.size = off
+	return nil
+}
+
+// scanRecord reads the record at off and adds it to the index if it is good. It returns the
+// offset of the end of the record according to its header.
+func (fs *FileStore) scanRecord(r io.Reader, hdr []byte, off int64) (int64, bool, error) {
+	end := off + recordHeaderSize
+	_, err := io.ReadFull(r, hdr)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return end, false, nil
+	} else if err != nil {
+		return 0, false, err
+	}
+	l := binary.BigEndian.Uint32(hdr)
+	end += int64(l) + recordTrailerSize
+	if l > maxRecordNode {
+		return end, false, nil
+	}
+
+	rest := make([]byte, int(l)+recordTrailerSize)
+	_, err = io.ReadFull(r, rest)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return end, false, nil
+	} else if err != nil {
+		return 0, false, err
+	}
+	crc := crc32.Update(crc32.Checksum(hdr[4:], crcTable), crcTable, rest[:l])
+	if crc != binary.BigEndian.Uint32(rest[l:]) {
+		return end, false, nil
+	}
+
+	fs.index[string(hdr[4:])] = fileLocation{off: off + recordHeaderSize, len: int(l)}
+	return end, true, nil
+}
+
+func (fs *FileStore) Get(hash []byte) ([]byte, error) {
+	loc, ok := fs.index[string(hash)]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	buf := make([]byte, loc.len)
+	_, err := fs.f.ReadAt(buf, loc.off)
+	if err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+func (fs *FileStore) Has(hash []byte) (bool, error) {
+	_, ok := fs.index[string(hash)]
+	return ok, nil
+}
+
+func (fs *FileStore) Put(hash, buf []byte) error {
+	if len(hash) != 32 {
+		return fmt.Errorf("mptrie: hash must be 32 bytes: %x", hash)
+	} else if len(buf) > maxRecordNode {
+		return errors.New("mptrie: node is too large")
+	}
+	if _, ok := fs.index[string(hash)]; ok {
+		return nil
+	}
+
+	rec := encodeRecord(hash, buf)
+	_, err := fs.f.WriteAt(rec, fs.size)
+	if err != nil {
+		return err
+	}
+	fs.index[string(hash)] = fileLocation{off: fs.size + recordHeaderSize, len: len(buf)}
+	fs.size += int64(len(rec))
+	return nil
+}
+
+// Sync commits the nodes which have been put to stable storage.
+func (fs *FileStore) Sync() error {
+	err := fs.f.Sync()
+	if err != nil {
+		return err
+	}
+	if fs.synced != fs.size {
+		err = writeSynced(fs.name, fs.size)
+		if err != nil {
+			return err
+		}
+		fs.synced = fs.size
+	}
+	return nil
+}
+
+func (fs *FileStore) Close() error {
+	return fs.f.Close()
+}
+
+func encodeRecord(hash, buf []byte) []byte {
+	rec := make([]byte, recordHeaderSize+len(buf)+recordTrailerSize)
+	binary.BigEndian.PutUint32(rec, uint32(len(buf)))
+	copy(rec[4:], hash)
+	copy(rec[recordHeaderSize:], buf)
+	binary.BigEndian.PutUint32(rec[recordHeaderSize+len(buf):],
+		crc32.Checksum(rec[4:recordHeaderSize+len(buf)], crcTable))
+	return rec
+}
+
+func compactName(name string) string {
+	return name + ".compact"
+}
+
+func syncedName(name string) string {
+	return name + ".synced"
+}
+
+// readSynced returns the size of the log at the last Sync, or 0 if it is not known. The size
+// is 8 bytes followed by a CRC-32C of them.
+func readSynced(name string) (int64, error) {
+	buf, err := os.ReadFile(syncedName(name))
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	if len(buf) != 12 || crc32.Checksum(buf[:8], crcTable) != binary.BigEndian.Uint32(buf[8:]) {
+		// The size was not completely written, so less of the log is known to be good.
+		return 0, nil
+	}
+	return int64(binary.BigEndian.Uint64(buf)), nil
+}
+
+func writeSynced(name string, size int64) error {
+	buf := make([]byte, 12)
+	binary.BigEndian.PutUint64(buf, uint64(size))
+	binary.BigEndian.PutUint32(buf[8:], crc32.Checksum(buf[:8], crcTable))
+
+	f, err := os.OpenFile(syncedName(name), os.O_WRONLY|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.WriteAt(buf, 0)
+	if err == nil {
+		err = f.Sync()
+	}
+	cerr := f.Close()
+	if err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Sweep compacts the log by copying the nodes in keep to a new file, which then replaces the
+// log. If the compaction is interrupted, the original log is left unchanged.
+func (fs *FileStore) Sweep(keep map[string]struct{}) (int64, error) {
+	var hashes []string
+	for hash := range fs.index {
+		if _, ok := keep[hash]; ok {
+			hashes = append(hashes, hash)
+		}
+	}
+	sort.Slice(hashes, func(i, j int) bool {
+		return fs.index[hashes[i]].off < fs.index[hashes[j]].off
+	})
+
+	name := compactName(fs.name)
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return 0, err
+	}
+
+	index, size, err := fs.copyNodes(f, hashes)
+	if err == nil {
+		err = f.Sync()
+	}
+	if err == nil {
+		// The compacted log is smaller, so forget the synced size until it replaces the log.
+		err = writeSynced(fs.name, 0)
+	}
+	if err == nil {
+		fs.synced = 0
+		err = os.Rename(name, fs.name)
+	}
+	if err != nil {
+		f.Close()
+		os.Remove(name)
+		return 0, err
+	}
+	syncDir(filepath.Dir(fs.name))
+
+	fs.f.Close()
+	reclaimed := fs.size - size
+	fs.f = f
+	fs.index = index
+	fs.size = size
+	err = fs.Sync()
+	if err != nil {
+		return 0, err
+	}
+	return reclaimed, nil
+}
+
+func (fs *FileStore) copyNodes(f *os.File, hashes []string) (map[string]fileLocation, int64,
+	error) {
+
+	w := bufio.NewWriter(f)
+	index := map[string]fileLocation{}
+	var size int64
+	for _, hash := range hashes {
+		buf, err := fs.Get([]byte(hash))
+		if err != nil {
+			return nil, 0, err
+		}
+		rec := encodeRecord([]byte(hash), buf)
+		_, err = w.Write(rec)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		index[hash] = fileLocation{off: size + recordHeaderSize, len: len(buf)}
+		size += int64(len(rec))
+	}
+
+	return index, size, w.Flush()
+}
+
+func syncDir(dir string) {
+	d, err := os.Open(dir)
+	if err != nil {
+		return
+	}
+	d.Sync()
+	d.Close()
+}
@@ -0,0 +1,241 @@
+package mptrie
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileStore(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "nodes.log")
+
+	fs, err := OpenFileStore(name)
+	if err != nil {
+		t.Fatalf("OpenFileStore() failed with %s", err)
+	}
+
+	mpt := New()
+	for i := 0; i < 200; i++ {
+		err := mpt.Put(testKey(i), testValue(i))
+		if err != nil {
+			t.Fatalf("Put(%d) failed with %s", i, err)
+		}
+	}
+	root1, err := mpt.Commit(fs)
+	if err != nil {
+		t.Fatalf("Commit() failed with %s", err)
+	}
+	cnt := len(fs.index)
+
+	err = mpt.Put(testKey(1000), testValue(1000))
+	if err != nil {
+		t.Fatalf("Put(%d) failed with %s", 1000, err)
+	}
+	root2, err := mpt.Commit(fs)
+	if err != nil {
+		t.Fatalf("Commit() failed with %s", err)
+	}
+	size := fs.size
+
+	err = fs.Close()
+	if err != nil {
+		t.Fatalf("Close() failed with %s", err)
+	}
+
+	fs, err = OpenFileStore(name)
+	if err != nil {
+		t.Fatalf("OpenFileStore() failed with %s", err)
+	}
+	if fs.size != size {
+		t.Errorf("OpenFileStore(): got size %d, want %d", fs.size, size)
+	}
+
+	for _, root := range [][]byte{root1, root2} {
+		omt, err := Open(root, fs)
+		if err != nil {
+			t.Fatalf("Open() failed with %s", err)
+		}
+		for i := 0; i < 200; i++ {
+			val, err := omt.Get(testKey(i))
+			if err != nil {
+				t.Errorf("Get(%d) failed with %s", i, err)
+			} else if !bytes.Equal(val, testValue(i)) {
+				t.Errorf("Get(%d): got %v, want %v", i, val, testValue(i))
+			}
+		}
+	}
+	fs.Close()
+
+	// Tear the last record, which is the root of root2.
+	err = os.Truncate(name, size-3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{0x01, 0x02})
+	f.Close()
+
+	fs, err = OpenFileStore(name)
+	if err != nil {
+		t.Fatalf("OpenFileStore() failed with %s", err)
+	}
+	defer fs.Close()
+
+	if _, err := fs.Get(root2); err != ErrNotFound {
+		t.Errorf("Get(root2): got %v, want not found", err)
+	}
+	if _, err := fs.Get(root1); err != nil {
+		t.Errorf("Get(root1) failed with %s", err)
+	}
+	if len(fs.index) <= cnt {
+		t.Errorf("OpenFileStore(): got %d nodes, want more than %d", len(fs.index), cnt)
+	}
+	fi, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	} else if fi.Size() != fs.size {
+		t.Errorf("OpenFileStore(): got file size %d, want %d", fi.Size(), fs.size)
+	}
+
+	omt, err := Open(root1, fs)
+	if err != nil {
+		t.Fatalf("Open() failed with %s", err)
+	}
+	err = omt.Put(testKey(1000), testValue(1000))
+	if err != nil {
+		t.Fatalf("Put(%d) failed with %s", 1000, err)
+	}
+	root, err := omt.Commit(fs)
+	if err != nil {
+		t.Fatalf("Commit() failed with %s", err)
+	} else if !bytes.Equal(root, root2) {
+		t.Errorf("Commit(): got %v, want %v", root, root2)
+	}
+	if _, err := fs.Get(root2); err != nil {
+		t.Errorf("Get(root2) failed with %s", err)
+	}
+}
+
+func TestFileStoreCorrupt(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "nodes.log")
+
+	fs, err := OpenFileStore(name)
+	if err != nil {
+		t.Fatalf("OpenFileStore() failed with %s", err)
+	}
+	mpt := New()
+	for i := 0; i < 200; i++ {
+		err := mpt.Put(testKey(i), testValue(i))
+		if err != nil {
+			t.Fatalf("Put(%d) failed with %s", i, err)
+		}
+	}
+	_, err = mpt.Commit(fs)
+	if err != nil {
+		t.Fatalf("Commit() failed with %s", err)
+	}
+	err = fs.Sync()
+	if err != nil {
+		t.Fatalf("Sync() failed with %s", err)
+	}
+	size := fs.size
+	fs.Close()
+
+	// Corrupt a node in the middle of the synced part of the file.
+	f, err := os.OpenFile(name, os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, 1)
+	_, err = f.ReadAt(b, size/2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b[0] ^= 0xFF
+	_, err = f.WriteAt(b, size/2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	_, err = OpenFileStore(name)
+	if err == nil {
+		t.Error("OpenFileStore() did not fail")
+	}
+	fi, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	} else if fi.Size() != size {
+		t.Errorf("OpenFileStore(): got file size %d, want %d", fi.Size(), size)
+	}
+}
+
+func TestFileStoreTornTail(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "nodes.log")
+
+	fs, err := OpenFileStore(name)
+	if err != nil {
+		t.Fatalf("OpenFileStore() failed with %s", err)
+	}
+	mpt := New()
+	for i := 0; i < 200; i++ {
+		err := mpt.Put(testKey(i), testValue(i))
+		if err != nil {
+			t.Fatalf("Put(%d) failed with %s", i, err)
+		}
+	}
+	root1, err := mpt.Commit(fs)
+	if err != nil {
+		t.Fatalf("Commit() failed with %s", err)
+	}
+	err = fs.Sync()
+	if err != nil {
+		t.Fatalf("Sync() failed with %s", err)
+	}
+	synced := fs.size
+
+	for i := 0; i < 100; i++ {
+		err := mpt.Put(testKey(i), append(testValue(i), 0xFF))
+		if err != nil {
+			t.Fatalf("Put(%d) failed with %s", i, err)
+		}
+	}
+	_, err = mpt.Commit(fs)
+	if err != nil {
+		t.Fatalf("Commit() failed with %s", err)
+	}
+	size := fs.size
+	fs.Close()
+
+	// A crash can leave the size of the file updated, but not its data, after the last Sync.
+	f, err := os.OpenFile(name, os.O_RDWR, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.WriteAt(make([]byte, 100), (synced+size)/2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = f.WriteAt(make([]byte, 200), size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	fs, err = OpenFileStore(name)
+	if err != nil {
+		t.Fatalf("OpenFileStore() failed with %s", err)
+	}
+	defer fs.Close()
+	if fs.size < synced || fs.size > (synced+size)/2 {
+		t.Errorf("OpenFileStore(): got size %d, want between %d and %d", fs.size, synced,
+			(synced+size)/2)
+	}
+	if cnt := countStoredKeys(t, fs, root1); cnt != 200 {
+		t.Errorf("OpenFileStore(): got %d keys, want 200", cnt)
+	}
+}
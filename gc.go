@@ -0,0 +1,74 @@
+package mptrie
+
+import "bytes"
+
+// SweepStore is a NodeStore which can remove nodes. Sweep removes every node whose hash is not
+// in keep, and returns the number of bytes reclaimed.
+type SweepStore interface {
+	NodeStore
+	Sweep(keep map[string]struct{}) (int64, error)
+}
+
+// CollectGarbage removes every node from store which can not be reached from one of roots, and
+// returns the number of bytes reclaimed. Nodes are only removed once all of the reachable
+// nodes have been found, so an interrupted collection can be started again from the beginning.
+// Tries still in memory may share removed nodes; Commit writes them again when they are needed.
+func CollectGarbage(store SweepStore, roots [][]byte) (int64, error) {
+	keep, err := markNodes(store, roots)
+	if err != nil {
+		return 0, err
+	}
+	return store.Sweep(keep)
+}
+
+func markNodes(store NodeStore, roots [][]byte) (map[string]struct{}, error) {
+	keep := map[string]struct{}{}
+
+	var stack [][]byte
+	for _, root := range roots {
+		if !bytes.Equal(root, emptyHash) {
+			stack = append(stack, root)
+		}
+	}
+
+	for len(stack) > 0 {
+		hash := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if _, ok := keep[string(hash)]; ok {
+			continue
+		}
+
+		buf, err := store.Get(hash)
+		if err == ErrNotFound {
+			return nil, &MissingNodeError{Hash: hash}
+		} else if err != nil {
+			return nil, err
+		}
+		n, err := decodeNode(buf)
+		if err != nil {
+			return nil, err
+		}
+
+		keep[string(hash)] = struct{}{}
+		stack = appendChildHashes(stack, n)
+	}
+
+	return keep, nil
+}
+
+// appendChildHashes appends the hashes of the children of n, including the children of
+// nodes included in n, to hashes.
+func appendChildHashes(hashes [][]byte, n node) [][]byte {
+	if branch, ok := n.(*branchNode); ok {
+		for _, child := range branch.children {
+			if child != nil {
+				hashes = appendChildHashes(hashes, child)
+			}
+		}
+	} else if extension, ok := n.(*extensionNode); ok {
+		hashes = appendChildHashes(hashes, extension.child)
+	} else if hn, ok := n.(hashNode); ok {
+		hashes = append(hashes, hn)
+	}
+	return hashes
+}
@@ -0,0 +1,197 @@
+package mptrie
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func commitVersions(t *testing.T, store NodeStore) [][]byte {
+	t.Helper()
+
+	var roots [][]byte
+	mpt := New()
+	for v := 0; v < 4; v++ {
+		for i := v * 50; i < v*50+200; i++ {
+			err := mpt.Put(testKey(i), append(testValue(i), byte(v)))
+			if err != nil {
+				t.Fatalf("Put(%d) failed with %s", i, err)
+			}
+		}
+		root, err := mpt.Commit(store)
+		if err != nil {
+			t.Fatalf("Commit() failed with %s", err)
+		}
+		roots = append(roots, root)
+	}
+	return roots
+}
+
+func checkRoot(t *testing.T, store NodeStore, root []byte, want bool) {
+	t.Helper()
+
+	mpt, err := Open(root, store)
+	if err != nil {
+		t.Fatalf("Open() failed with %s", err)
+	}
+	it := mpt.Iterator(nil)
+	cnt := 0
+	for it.Next() {
+		cnt += 1
+	}
+	if want {
+		if it.Err() != nil {
+			t.Errorf("Iterator(%x) failed with %s", root, it.Err())
+		} else if cnt != 200 && cnt != 250 && cnt != 300 && cnt != 350 {
+			t.Errorf("Iterator(%x): got %d keys", root, cnt)
+		}
+	} else if it.Err() == nil {
+		t.Errorf("Iterator(%x): got %d keys, want missing node", root, cnt)
+	}
+}
+
+func TestCollectGarbageFork(t *testing.T) {
+	fs, err := OpenFileStore(filepath.Join(t.TempDir(), "nodes.log"))
+	if err != nil {
+		t.Fatalf("OpenFileStore() failed with %s", err)
+	}
+	defer fs.Close()
+
+	for _, store := range []SweepStore{NewMemoryStore(), fs} {
+		mpt := New()
+		for i := 0; i < 200; i++ {
+			err := mpt.Put(testKey(i), testValue(i))
+			if err != nil {
+				t.Fatalf("Put(%d) failed with %s", i, err)
+			}
+		}
+		_, err := mpt.Commit(store)
+		if err != nil {
+			t.Fatalf("Commit() failed with %s", err)
+		}
+
+		fork := mpt.Clone()
+		for i := 0; i < 200; i += 2 {
+			err := mpt.Put(testKey(i), append(testValue(i), 0xFF))
+			if err != nil {
+				t.Fatalf("Put(%d) failed with %s", i, err)
+			}
+		}
+		root2, err := mpt.Commit(store)
+		if err != nil {
+			t.Fatalf("Commit() failed with %s", err)
+		}
+		_, err = CollectGarbage(store, [][]byte{root2})
+		if err != nil {
+			t.Fatalf("CollectGarbage() failed with %s", err)
+		}
+
+		// The nodes which the fork only shared with root1 have been removed, so they must be
+		// written again.
+		err = fork.Put(testKey(1000), testValue(1000))
+		if err != nil {
+			t.Fatalf("Put(1000) failed with %s", err)
+		}
+		root3, err := fork.Commit(store)
+		if err != nil {
+			t.Fatalf("Commit(fork) failed with %s", err)
+		}
+		_, err = markNodes(store, [][]byte{root2, root3})
+		if err != nil {
+			t.Errorf("markNodes() failed with %s", err)
+		}
+		if cnt := countStoredKeys(t, store, root3); cnt != 201 {
+			t.Errorf("Commit(fork): got %d keys, want 201", cnt)
+		}
+	}
+}
+
+func TestCollectGarbage(t *testing.T) {
+	ms := NewMemoryStore()
+	roots := commitVersions(t, ms)
+	n := ms.Len()
+
+	reclaimed, err := CollectGarbage(ms, roots)
+	if err != nil {
+		t.Fatalf("CollectGarbage() failed with %s", err)
+	} else if reclaimed != 0 || ms.Len() != n {
+		t.Errorf("CollectGarbage(all roots): got %d bytes and %d nodes, want 0 and %d",
+			reclaimed, ms.Len(), n)
+	}
+
+	reclaimed, err = CollectGarbage(ms, [][]byte{roots[1], roots[3]})
+	if err != nil {
+		t.Fatalf("CollectGarbage() failed with %s", err)
+	} else if reclaimed == 0 || ms.Len() >= n {
+		t.Errorf("CollectGarbage(): got %d bytes and %d nodes", reclaimed, ms.Len())
+	}
+	checkRoot(t, ms, roots[0], false)
+	checkRoot(t, ms, roots[1], true)
+	checkRoot(t, ms, roots[2], false)
+	checkRoot(t, ms, roots[3], true)
+
+	_, err = CollectGarbage(ms, [][]byte{roots[0]})
+	if _, ok := err.(*MissingNodeError); !ok {
+		t.Errorf("CollectGarbage(missing root): got %v, want missing node", err)
+	}
+
+	reclaimed, err = CollectGarbage(ms, [][]byte{emptyHash})
+	if err != nil {
+		t.Fatalf("CollectGarbage() failed with %s", err)
+	} else if reclaimed == 0 || ms.Len() != 0 {
+		t.Errorf("CollectGarbage(empty): got %d bytes and %d nodes", reclaimed, ms.Len())
+	}
+}
+
+func TestFileStoreCollectGarbage(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "nodes.log")
+
+	// Leave behind an unfinished compaction.
+	err := os.WriteFile(compactName(name), []byte("unfinished"), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fs, err := OpenFileStore(name)
+	if err != nil {
+		t.Fatalf("OpenFileStore() failed with %s", err)
+	}
+	if _, err := os.Stat(compactName(name)); !os.IsNotExist(err) {
+		t.Errorf("OpenFileStore() did not remove %s", compactName(name))
+	}
+
+	roots := commitVersions(t, fs)
+	size := fs.size
+
+	reclaimed, err := CollectGarbage(fs, roots[3:])
+	if err != nil {
+		t.Fatalf("CollectGarbage() failed with %s", err)
+	} else if reclaimed <= 0 || fs.size != size-reclaimed {
+		t.Errorf("CollectGarbage(): got %d bytes, size %d, was %d", reclaimed, fs.size, size)
+	}
+	checkRoot(t, fs, roots[2], false)
+	checkRoot(t, fs, roots[3], true)
+
+	err = fs.Close()
+	if err != nil {
+		t.Fatalf("Close() failed with %s", err)
+	}
+	fs, err = OpenFileStore(name)
+	if err != nil {
+		t.Fatalf("OpenFileStore() failed with %s", err)
+	}
+	defer fs.Close()
+
+	if fs.size != size-reclaimed {
+		t.Errorf("OpenFileStore(): got size %d, want %d", fs.size, size-reclaimed)
+	}
+	checkRoot(t, fs, roots[3], true)
+
+	buf, err := fs.Get(roots[3])
+	if err != nil {
+		t.Errorf("Get(%x) failed with %s", roots[3], err)
+	} else if !bytes.Equal(keccak256(buf), roots[3]) {
+		t.Errorf("Get(%x): got %v", roots[3], buf)
+	}
+}
@@ -2,7 +2,6 @@ module github.com/leftmike/mptrie
 
 go 1.17
 
-require (
-	golang.org/x/crypto v0.0.0-20211117183948-ae814b36b871 // indirect
-	golang.org/x/sys v0.0.0-20210615035016-665e8c7367d1 // indirect
-)
+require golang.org/x/crypto v0.0.0-20211117183948-ae814b36b871
+
+require golang.org/x/sys v0.0.0-20210615035016-665e8c7367d1 // indirect
@@ -0,0 +1,129 @@
+package mptrie
+
+import (
+	"bytes"
+	"fmt"
+)
+
+type iteratorFrame struct {
+	n    node
+	path nibbleKey
+	ci   int // The next child of a branch to visit; -1 if the value has not been visited.
+}
+
+// Iterator returns the keys and values of a trie in lexicographic key order.
+type Iterator struct {
+	mpt   *MPTrie
+	start nibbleKey
+	stack []iteratorFrame
+	key   []byte
+	value []byte
+	err   error
+}
+
+// Iterator returns an iterator which starts at the first key greater than or equal to start.
+// The trie must not be changed while the iterator is in use.
+func (mpt *MPTrie) Iterator(start []byte) *Iterator {
+	it := &Iterator{
+		mpt:   mpt,
+		start: keyToNibbleKey(start),
+	}
+	if mpt.root != nil {
+		it.stack = append(it.stack, iteratorFrame{n: mpt.root, ci: -1})
+	}
+	return it
+}
+
+// skip returns true if every key starting with prefix is before the start of the iterator.
+func (it *Iterator) skip(prefix nibbleKey) bool {
+	l := len(prefix)
+	if l > len(it.start) {
+		l = len(it.start)
+	}
+	return bytes.Compare(prefix[:l], it.start[:l]) < 0
+}
+
+func (it *Iterator) push(n node, path nibbleKey) {
+	if !it.skip(path) {
+		it.stack = append(it.stack, iteratorFrame{n: n, path: path, ci: -1})
+	}
+}
+
+func (it *Iterator) pop() iteratorFrame {
+	f := it.stack[len(it.stack)-1]
+	it.stack = it.stack[:len(it.stack)-1]
+	return f
+}
+
+// Next moves the iterator to the next key and value, and returns false when there are no
+// more keys or an error was encountered.
+func (it *Iterator) Next() bool {
+	it.key = nil
+	it.value = nil
+
+	for len(it.stack) > 0 {
+		f := &it.stack[len(it.stack)-1]
+		if hn, ok := f.n.(hashNode); ok {
+			n, err := it.mpt.resolve(hn, f.path)
+			if err != nil {
+				it.err = err
+				it.stack = nil
+				return false
+			}
+			f.n = n
+		}
+
+		if branch, ok := f.n.(*branchNode); ok {
+			if f.ci < 0 {
+				f.ci = 0
+				if branch.value != nil && bytes.Compare(f.path, it.start) >= 0 {
+					it.key = nibbleKeyToKey(f.path)
+					it.value = branch.value
+					return true
+				}
+			}
+
+			for f.ci < len(branch.children) && branch.children[f.ci] == nil {
+				f.ci += 1
+			}
+			if f.ci == len(branch.children) {
+				it.pop()
+				continue
+			}
+
+			ci := f.ci
+			f.ci += 1
+			it.push(branch.children[ci], joinNibbleKeys(f.path, nibbleKey{byte(ci)}))
+		} else if extension, ok := f.n.(*extensionNode); ok {
+			f := it.pop()
+			it.push(extension.child, joinNibbleKeys(f.path, extension.subKey))
+		} else if leaf, ok := f.n.(*leafNode); ok {
+			f := it.pop()
+			nk := joinNibbleKeys(f.path, leaf.suffixKey)
+			if bytes.Compare(nk, it.start) >= 0 {
+				it.key = nibbleKeyToKey(nk)
+				it.value = leaf.value
+				return true
+			}
+		} else {
+			panic(fmt.Sprintf("unexpected mptrie node: %#v", f.n))
+		}
+	}
+
+	return false
+}
+
+// Key returns the current key.
+func (it *Iterator) Key() []byte {
+	return it.key
+}
+
+// Value returns the value of the current key.
+func (it *Iterator) Value() []byte {
+	return it.value
+}
+
+// Err returns the error, if any, that stopped the iterator.
+func (it *Iterator) Err() error {
+	return it.err
+}
@@ -0,0 +1,81 @@
+package mptrie_test
+
+import (
+	"bytes"
+	"math/rand"
+	"sort"
+	"testing"
+
+	"github.com/leftmike/mptrie"
+)
+
+func randomKeys(r *rand.Rand, n int) [][]byte {
+	keys := map[string]struct{}{}
+	for len(keys) < n {
+		key := make([]byte, 1+r.Intn(4))
+		for ki := range key {
+			key[ki] = byte(r.Intn(4) * 0x11)
+		}
+		keys[string(key)] = struct{}{}
+	}
+
+	var sorted [][]byte
+	for k := range keys {
+		sorted = append(sorted, []byte(k))
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i], sorted[j]) < 0
+	})
+	return sorted
+}
+
+func testIterator(t *testing.T, mpt *mptrie.MPTrie, start []byte, keys [][]byte) {
+	t.Helper()
+
+	it := mpt.Iterator(start)
+	for _, k := range keys {
+		if bytes.Compare(k, start) < 0 {
+			continue
+		}
+
+		if !it.Next() {
+			t.Fatalf("Iterator(%v).Next(): got false, want key %v", start, k)
+		}
+		if !bytes.Equal(it.Key(), k) {
+			t.Errorf("Iterator(%v).Key(): got %v, want %v", start, it.Key(), k)
+		}
+		if want := append([]byte("value"), k...); !bytes.Equal(it.Value(), want) {
+			t.Errorf("Iterator(%v).Value(): got %v, want %v", start, it.Value(), want)
+		}
+	}
+
+	if it.Next() {
+		t.Errorf("Iterator(%v).Next(): got key %v, want false", start, it.Key())
+	}
+	if it.Err() != nil {
+		t.Errorf("Iterator(%v).Err(): got %s", start, it.Err())
+	}
+}
+
+func TestIterator(t *testing.T) {
+	testIterator(t, mptrie.New(), nil, nil)
+
+	r := rand.New(rand.NewSource(1))
+	keys := randomKeys(r, 100)
+
+	mpt := mptrie.New()
+	for _, k := range keys {
+		err := mpt.Put(k, append([]byte("value"), k...))
+		if err != nil {
+			t.Fatalf("mpt.Put(%v) failed with %s", k, err)
+		}
+	}
+
+	testIterator(t, mpt, nil, keys)
+	testIterator(t, mpt, []byte{}, keys)
+	for _, k := range keys {
+		testIterator(t, mpt, k, keys)
+		testIterator(t, mpt, append(k, 0x01), keys)
+	}
+	testIterator(t, mpt, []byte{0xFF}, keys)
+}
@@ -1,5 +1,10 @@
 package mptrie
 
+import (
+	"errors"
+	"fmt"
+)
+
 type nibbleKey []byte
 
 type hexPrefixKey []byte
@@ -22,6 +27,12 @@ func commonPrefix(k1, k2 nibbleKey) int {
 	return l
 }
 
+func joinNibbleKeys(k1, k2 nibbleKey) nibbleKey {
+	nk := make(nibbleKey, 0, len(k1)+len(k2))
+	nk = append(nk, k1...)
+	return append(nk, k2...)
+}
+
 func encodeHexPrefix(nk nibbleKey, tf bool) []byte {
 	var ni int
 	var b byte
@@ -46,3 +57,31 @@ func encodeHexPrefix(nk nibbleKey, tf bool) []byte {
 
 	return buf
 }
+
+func nibbleKeyToKey(nk nibbleKey) []byte {
+	key := make([]byte, len(nk)/2)
+	for ki := range key {
+		key[ki] = (nk[ki*2] << 4) | nk[ki*2+1]
+	}
+	return key
+}
+
+func decodeHexPrefix(buf []byte) (nibbleKey, bool, error) {
+	if len(buf) == 0 {
+		return nil, false, errors.New("mptrie: empty hex prefix key")
+	}
+
+	flags := buf[0] >> 4
+	if flags > 3 || (flags&0x01 == 0 && buf[0]&0x0F != 0) {
+		return nil, false, fmt.Errorf("mptrie: bad hex prefix flags: %#x", buf[0])
+	}
+
+	nk := make(nibbleKey, 0, len(buf)*2)
+	if flags&0x01 != 0 {
+		nk = append(nk, buf[0]&0x0F)
+	}
+	for _, b := range buf[1:] {
+		nk = append(nk, b>>4, b&0x0F)
+	}
+	return nk, flags&0x02 != 0, nil
+}
@@ -60,3 +60,32 @@ func TestEncodeHexPrefix(t *testing.T) {
 		}
 	}
 }
+
+func TestDecodeHexPrefix(t *testing.T) {
+	cases := []nibbleKey{
+		{},
+		{0x01},
+		{0x01, 0x02},
+		{0x0A, 0x0B, 0x0C},
+		{0x0A, 0x0B, 0x0C, 0x0D},
+		{0x01, 0x02, 0x03, 0x04, 0x05},
+	}
+
+	for _, nk := range cases {
+		for _, tf := range []bool{false, true} {
+			dnk, dtf, err := decodeHexPrefix(encodeHexPrefix(nk, tf))
+			if err != nil {
+				t.Errorf("decodeHexPrefix(%v %v) failed with %s", nk, tf, err)
+			} else if !bytes.Equal(dnk, nk) || dtf != tf {
+				t.Errorf("decodeHexPrefix(%v %v): got %v %v", nk, tf, dnk, dtf)
+			}
+		}
+	}
+
+	for _, buf := range [][]byte{{}, {0x40}, {0x01}, {0x21, 0x23}} {
+		_, _, err := decodeHexPrefix(buf)
+		if err == nil {
+			t.Errorf("decodeHexPrefix(%v): did not fail", buf)
+		}
+	}
+}
@@ -17,12 +17,34 @@ type MPTrie struct {
 	root       node
 	generation int64
 	hash       []byte
+	store      NodeStore
+	pathStore  *PathStore
+	owner      []byte
+	recorder   *Recorder
 }
 
 func New() *MPTrie {
 	return &MPTrie{}
 }
 
+// Open returns a trie with the given root hash whose nodes are loaded from store as they are
+// needed. Loaded nodes are kept in the trie, and in any clones which share them, so even
+// reading the trie, with Get for example, changes it: a trie which has been opened, and its
+// clones, must not be used concurrently, not even by readers.
+func Open(root []byte, store NodeStore) (*MPTrie, error) {
+	if len(root) != 32 {
+		return nil, fmt.Errorf("mptrie: root hash must be 32 bytes: %x", root)
+	}
+
+	mpt := &MPTrie{
+		store: store,
+	}
+	if !bytes.Equal(root, emptyHash) {
+		mpt.root = hashNode(append([]byte(nil), root...))
+	}
+	return mpt, nil
+}
+
 func (mpt *MPTrie) String() string {
 	if mpt.root == nil {
 		return "<nil>\n"
@@ -33,66 +55,87 @@ func (mpt *MPTrie) String() string {
 	return sb.String()
 }
 
+// Clone returns a copy of the trie which shares all of its nodes with the original. Nodes
+// belonging to an older generation are copied before being changed, so the original and the
+// clone are independent of each other.
 func (mpt *MPTrie) Clone() *MPTrie {
 	mpt.generation += 1
 	clone := *mpt
 	return &clone
 }
 
-func (mpt *MPTrie) deleteBranch(branch *branchNode, nk nibbleKey) (node, error) {
+func (mpt *MPTrie) deleteBranch(branch *branchNode, nk, fk nibbleKey) (node, error) {
+	remaining := *branch
 	if len(nk) == 0 {
 		if branch.value == nil {
 			return nil, ErrNotFound
 		}
-		branch.value = nil
+		remaining.value = nil
 	} else if branch.children[nk[0]] != nil {
-		n, err := mpt.deleteNode(branch.children[nk[0]], nk[1:])
+		n, err := mpt.deleteNode(branch.children[nk[0]], nk[1:], fk)
 		if err != nil {
 			return nil, err
 		}
-		branch.children[nk[0]] = n
 		if n != nil {
+			branch = mpt.mutableBranchNode(branch)
+			branch.children[nk[0]] = n
 			return branch, nil
 		}
+		remaining.children[nk[0]] = nil
 	} else {
 		return nil, ErrNotFound
 	}
 
-	// A child or the value was deleted; maybe this branch can be deleted as well.
+	// A child or the value is being deleted; maybe this branch can be deleted as well. The
+	// branch is not changed until the only remaining child, if any, has been resolved.
+
+	if remaining.value == nil {
+		if ck, onlyChild := remaining.onlyChild(); onlyChild != nil {
+			cp := joinNibbleKeys(fk[:len(fk)-len(nk)], ck)
+			if hn, ok := onlyChild.(hashNode); ok {
+				var err error
+				onlyChild, err = mpt.resolve(hn, cp)
+				if err != nil {
+					return nil, err
+				}
+			}
+			mpt.record(onlyChild, cp)
 
-	if branch.value == nil {
-		if ck, onlyChild := branch.onlyChild(); onlyChild != nil {
 			if child, ok := onlyChild.(*branchNode); ok {
 				extension := mpt.newExtensionNode(ck)
 				extension.child = child
 				return extension, nil
 			} else if child, ok := onlyChild.(*extensionNode); ok {
-				child.subKey = append(ck, child.subKey...)
+				child = mpt.mutableExtensionNode(child)
+				child.subKey = joinNibbleKeys(ck, child.subKey)
 				return child, nil
 			} else if child, ok := onlyChild.(*leafNode); ok {
-				child.suffixKey = append(ck, child.suffixKey...)
+				child = mpt.mutableLeafNode(child)
+				child.suffixKey = joinNibbleKeys(ck, child.suffixKey)
 				return child, nil
 			}
 
 			panic(fmt.Sprintf("unexpected mptrie node: %#v", onlyChild))
 		}
 	} else {
-		if branch.noChildren() {
-			return mpt.newLeafNode([]byte{}, branch.value), nil
+		if remaining.noChildren() {
+			return mpt.newLeafNode([]byte{}, remaining.value), nil
 		}
 	}
 
+	branch = mpt.mutableBranchNode(branch)
+	branch.value = remaining.value
+	branch.children = remaining.children
 	return branch, nil
-
 }
 
-func (mpt *MPTrie) deleteExtension(extension *extensionNode, nk nibbleKey) (node, error) {
+func (mpt *MPTrie) deleteExtension(extension *extensionNode, nk, fk nibbleKey) (node, error) {
 	l := len(extension.subKey)
 	if len(nk) < l || !bytes.Equal(nk[:l], extension.subKey) {
 		return nil, ErrNotFound
 	}
 
-	n, err := mpt.deleteNode(extension.child, nk[l:])
+	n, err := mpt.deleteNode(extension.child, nk[l:], fk)
 	if err != nil {
 		return nil, err
 	}
@@ -101,13 +144,16 @@ func (mpt *MPTrie) deleteExtension(extension *extensionNode, nk nibbleKey) (node
 		// than one key. Hence, deleting _one_ key from an extension must never be nil.
 		panic("extension must always point to multiple keys")
 	} else if child, ok := n.(*extensionNode); ok {
-		extension.subKey = append(extension.subKey, child.subKey...)
+		extension = mpt.mutableExtensionNode(extension)
+		extension.subKey = joinNibbleKeys(extension.subKey, child.subKey)
 		extension.child = child.child
 		return extension, nil
 	} else if child, ok := n.(*leafNode); ok {
-		child.suffixKey = append(extension.subKey, child.suffixKey...)
+		child = mpt.mutableLeafNode(child)
+		child.suffixKey = joinNibbleKeys(extension.subKey, child.suffixKey)
 		return child, nil
 	} else if child, ok := n.(*branchNode); ok {
+		extension = mpt.mutableExtensionNode(extension)
 		extension.child = child
 		return extension, nil
 	} else {
@@ -115,11 +161,22 @@ func (mpt *MPTrie) deleteExtension(extension *extensionNode, nk nibbleKey) (node
 	}
 }
 
-func (mpt *MPTrie) deleteNode(n node, nk nibbleKey) (node, error) {
+// deleteNode deletes the rest of the key, nk, from n; fk is the full key and is used to
+// resolve hash nodes.
+func (mpt *MPTrie) deleteNode(n node, nk, fk nibbleKey) (node, error) {
+	if hn, ok := n.(hashNode); ok {
+		var err error
+		n, err = mpt.resolve(hn, fk[:len(fk)-len(nk)])
+		if err != nil {
+			return nil, err
+		}
+	}
+	mpt.record(n, fk[:len(fk)-len(nk)])
+
 	if branch, ok := n.(*branchNode); ok {
-		return mpt.deleteBranch(branch, nk)
+		return mpt.deleteBranch(branch, nk, fk)
 	} else if extension, ok := n.(*extensionNode); ok {
-		return mpt.deleteExtension(extension, nk)
+		return mpt.deleteExtension(extension, nk, fk)
 	} else if leaf, ok := n.(*leafNode); ok {
 		if bytes.Equal(nk, leaf.suffixKey) {
 			return nil, nil
@@ -138,7 +195,8 @@ func (mpt *MPTrie) Delete(key []byte) error {
 		return ErrNotFound
 	}
 
-	n, err := mpt.deleteNode(mpt.root, keyToNibbleKey(key))
+	nk := keyToNibbleKey(key)
+	n, err := mpt.deleteNode(mpt.root, nk, nk)
 	if err != nil {
 		return err
 	}
@@ -146,12 +204,21 @@ func (mpt *MPTrie) Delete(key []byte) error {
 	return nil
 }
 
+// Get returns the value of key. Nodes which are loaded from the store on the way to the key
+// are kept in the trie; see Open.
 func (mpt *MPTrie) Get(key []byte) ([]byte, error) {
-	nk := keyToNibbleKey(key)
-	n := mpt.root
+	fk := keyToNibbleKey(key)
+	nk := fk
+	pn := &mpt.root
 
-	for n != nil {
-		if branch, ok := n.(*branchNode); ok {
+	for (*pn) != nil {
+		err := mpt.resolveChild(pn, fk[:len(fk)-len(nk)])
+		if err != nil {
+			return nil, err
+		}
+		mpt.record(*pn, fk[:len(fk)-len(nk)])
+
+		if branch, ok := (*pn).(*branchNode); ok {
 			if len(nk) == 0 {
 				if branch.value == nil {
 					return nil, ErrNotFound
@@ -159,24 +226,24 @@ func (mpt *MPTrie) Get(key []byte) ([]byte, error) {
 				return branch.value, nil
 			}
 
-			n = branch.children[nk[0]]
+			pn = &branch.children[nk[0]]
 			nk = nk[1:]
-		} else if extension, ok := n.(*extensionNode); ok {
+		} else if extension, ok := (*pn).(*extensionNode); ok {
 			l := len(extension.subKey)
 			if len(nk) < l || !bytes.Equal(nk[:l], extension.subKey) {
 				return nil, ErrNotFound
 			}
 
 			nk = nk[l:]
-			n = extension.child
-		} else if leaf, ok := n.(*leafNode); ok {
+			pn = &extension.child
+		} else if leaf, ok := (*pn).(*leafNode); ok {
 			if bytes.Equal(nk, leaf.suffixKey) {
 				return leaf.value, nil
 			}
 
 			return nil, ErrNotFound
 		} else {
-			panic(fmt.Sprintf("unexpected mptrie node: %#v", n))
+			panic(fmt.Sprintf("unexpected mptrie node: %#v", *pn))
 		}
 	}
 
@@ -199,11 +266,20 @@ func (mpt *MPTrie) Hash() []byte {
 func (mpt *MPTrie) Put(key, val []byte) error {
 	mpt.hash = nil
 
-	nk := keyToNibbleKey(key)
+	fk := keyToNibbleKey(key)
+	nk := fk
 	pn := &mpt.root
 
 	for (*pn) != nil {
+		err := mpt.resolveChild(pn, fk[:len(fk)-len(nk)])
+		if err != nil {
+			return err
+		}
+		mpt.record(*pn, fk[:len(fk)-len(nk)])
+
 		if branch, ok := (*pn).(*branchNode); ok {
+			branch = mpt.mutableBranchNode(branch)
+			*pn = branch
 			if len(nk) == 0 {
 				branch.value = val
 				return nil
@@ -214,10 +290,23 @@ func (mpt *MPTrie) Put(key, val []byte) error {
 		} else if extension, ok := (*pn).(*extensionNode); ok {
 			cpl := commonPrefix(nk, extension.subKey)
 			if cpl == len(extension.subKey) {
+				extension = mpt.mutableExtensionNode(extension)
+				*pn = extension
 				nk = nk[cpl:]
 
 				// The child of an extension is _always_ a branch; handle it here.
-				branch := extension.child
+				err := mpt.resolveChild(&extension.child, fk[:len(fk)-len(nk)])
+				if err != nil {
+					return err
+				}
+				mpt.record(extension.child, fk[:len(fk)-len(nk)])
+				child, ok := extension.child.(*branchNode)
+				if !ok {
+					panic(fmt.Sprintf("extension.child must be a branch node: %#v",
+						extension.child))
+				}
+				branch := mpt.mutableBranchNode(child)
+				extension.child = branch
 				if len(nk) == 0 {
 					branch.value = val
 					return nil
@@ -238,6 +327,7 @@ func (mpt *MPTrie) Put(key, val []byte) error {
 				if len(extension.subKey) == cpl+1 {
 					newBranch.children[extension.subKey[cpl]] = extension.child
 				} else {
+					extension = mpt.mutableExtensionNode(extension)
 					newBranch.children[extension.subKey[cpl]] = extension
 					extension.subKey = extension.subKey[cpl+1:]
 				}
@@ -252,8 +342,10 @@ func (mpt *MPTrie) Put(key, val []byte) error {
 				break
 			}
 		} else if leaf, ok := (*pn).(*leafNode); ok {
+			leaf = mpt.mutableLeafNode(leaf)
 			if bytes.Equal(nk, leaf.suffixKey) {
 				leaf.value = val
+				*pn = leaf
 				return nil
 			}
 
@@ -293,9 +385,11 @@ func (mpt *MPTrie) Put(key, val []byte) error {
 	return nil
 }
 
+// Encode returns the RLP encoding of the trie with every node included in full in its parent.
+// Nodes which have not been loaded from the store are referred to by their hash.
 func (mpt *MPTrie) Encode() []byte {
 	if mpt.root == nil {
 		return nil
 	}
-	return mpt.root.encode()
+	return encodeNested(mpt.root)
 }
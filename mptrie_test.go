@@ -22,6 +22,92 @@ func TestClone(t *testing.T) {
 	}
 }
 
+func putKeys(t *testing.T, mpt *mptrie.MPTrie, keys [][]byte, val string) {
+	t.Helper()
+
+	for _, k := range keys {
+		err := mpt.Put(k, []byte(fmt.Sprintf("%s-%x", val, k)))
+		if err != nil {
+			t.Fatalf("mpt.Put(%v) failed with %s", k, err)
+		}
+	}
+}
+
+func checkKeys(t *testing.T, mpt *mptrie.MPTrie, keys [][]byte, val string) {
+	t.Helper()
+
+	for _, k := range keys {
+		v, err := mpt.Get(k)
+		if err != nil {
+			t.Errorf("mpt.Get(%v) failed with %s", k, err)
+		} else if want := fmt.Sprintf("%s-%x", val, k); string(v) != want {
+			t.Errorf("mpt.Get(%v): got %s, want %s", k, v, want)
+		}
+	}
+}
+
+func TestCloneCopyOnWrite(t *testing.T) {
+	keys := [][]byte{
+		{0x00, 0x12, 0x34},
+		{0x00, 0x12, 0x35},
+		{0x00, 0x23, 0x45},
+		{0x01, 0x23, 0x45, 0x67},
+		{0x01, 0x23, 0x45, 0x67, 0x89},
+		{0xA0, 0x12, 0x34},
+	}
+	moreKeys := [][]byte{
+		{0x00, 0x12},
+		{0x01, 0x23, 0x45, 0x00},
+		{0xA0, 0x12, 0x34, 0x56},
+	}
+
+	mpt := mptrie.New()
+	putKeys(t, mpt, keys, "orig")
+	h := mpt.Hash()
+
+	clone := mpt.Clone()
+	putKeys(t, clone, keys, "clone")
+	putKeys(t, clone, moreKeys, "clone")
+	if err := clone.Delete(keys[0]); err != nil {
+		t.Errorf("clone.Delete(%v) failed with %s", keys[0], err)
+	}
+	if err := clone.Delete(keys[3]); err != nil {
+		t.Errorf("clone.Delete(%v) failed with %s", keys[3], err)
+	}
+
+	checkKeys(t, mpt, keys, "orig")
+	if !bytes.Equal(mpt.Hash(), h) {
+		t.Errorf("mpt.Hash() changed after modifying clone")
+	}
+
+	cloneHash := clone.Hash()
+	for _, k := range moreKeys {
+		if err := mpt.Put(k, []byte("orig")); err != nil {
+			t.Errorf("mpt.Put(%v) failed with %s", k, err)
+		}
+	}
+	for _, k := range keys {
+		if err := mpt.Delete(k); err != nil {
+			t.Errorf("mpt.Delete(%v) failed with %s", k, err)
+		}
+	}
+
+	checkKeys(t, clone, keys[1:3], "clone")
+	checkKeys(t, clone, keys[4:], "clone")
+	checkKeys(t, clone, moreKeys, "clone")
+	if !bytes.Equal(clone.Hash(), cloneHash) {
+		t.Errorf("clone.Hash() changed after modifying original")
+	}
+
+	want := mptrie.New()
+	putKeys(t, want, keys[1:3], "clone")
+	putKeys(t, want, keys[4:], "clone")
+	putKeys(t, want, moreKeys, "clone")
+	if !bytes.Equal(clone.Hash(), want.Hash()) {
+		t.Errorf("clone.Hash(): got %v, want %v", clone.Hash(), want.Hash())
+	}
+}
+
 type testOp int
 
 const (
@@ -481,3 +567,45 @@ func TestBasic(t *testing.T) {
 			},
 		})
 }
+
+func TestEncode(t *testing.T) {
+	if buf := mptrie.New().Encode(); buf != nil {
+		t.Errorf("Encode(): got %x, want nil", buf)
+	}
+
+	cases := []struct {
+		kvs [][2]string
+		buf string
+	}{
+		{
+			kvs: [][2]string{{"\x01\x02", "v"}, {"\x01\x03", "w"}},
+			buf: "d9821010d58080c22076c2207780808080808080808080808080",
+		},
+		{
+			kvs: [][2]string{{"doe", "reindeer"}, {"dog", "puppy"}, {"dogglesworth", "cat"}},
+			buf: "f845831646f6f83f8080808080ca20887265696e6465657280e4808080808080ce89376c6573" +
+				"776f72746883636174808080808080808080857075707079808080808080808080",
+		},
+		{
+			kvs: [][2]string{{"do", "verb"}, {"dog", "puppy"}, {"doge", "coin"},
+				{"horse", "stallion"}},
+			buf: "f85a16f85780808080f782006ff3808080808080de17dc808080808080c63584636f696e8080" +
+				"808080808080808570757070798080808080808080808476657262808080cf85206f727365" +
+				"887374616c6c696f6e8080808080808080",
+		},
+	}
+
+	for _, c := range cases {
+		mpt := mptrie.New()
+		for _, kv := range c.kvs {
+			err := mpt.Put([]byte(kv[0]), []byte(kv[1]))
+			if err != nil {
+				t.Fatalf("Put(%x) failed with %s", kv[0], err)
+			}
+		}
+
+		if buf := fmt.Sprintf("%x", mpt.Encode()); buf != c.buf {
+			t.Errorf("Encode(): got %s, want %s", buf, c.buf)
+		}
+	}
+}
@@ -9,7 +9,7 @@ import (
 )
 
 type node interface {
-	encode() []byte // XXX: needed?
+	encode() []byte
 	hash(rf bool) []byte
 	toString(w io.Writer, depth int)
 }
@@ -22,10 +22,54 @@ func keccak256(data ...[]byte) []byte {
 	return h.Sum(nil)
 }
 
+// nodeRef returns how a node is referred to by its parent: nodes which encode to less than 32
+// bytes are included directly, otherwise the keccak256 hash of the encoding is used.
+func nodeRef(buf []byte) []byte {
+	if len(buf) < 32 {
+		return buf
+	}
+	return encodeBytes(nil, keccak256(buf))
+}
+
+func refHash(ref []byte, rf bool) []byte {
+	if !rf {
+		return ref
+	}
+	if len(ref) < 32 {
+		return keccak256(ref)
+	}
+	return ref[1:]
+}
+
+// Nodes decoded from their encoding do not belong to any generation of a trie, so they are
+// always copied before being changed.
+const decodedGeneration = -1
+
+// hashNode is a reference, by hash, to a node which has not been decoded.
+type hashNode []byte
+
+func (hn hashNode) encode() []byte {
+	panic(fmt.Sprintf("mptrie: hash node can not be encoded: %x", []byte(hn)))
+}
+
+func (hn hashNode) hash(rf bool) []byte {
+	if rf {
+		return hn
+	}
+	return encodeBytes(nil, hn)
+}
+
+func (hn hashNode) toString(w io.Writer, depth int) {
+	fmt.Fprint(w, strings.Repeat("  ", depth))
+	fmt.Fprintf(w, "<%x>\n", []byte(hn))
+}
+
 type leafNode struct {
 	suffixKey  nibbleKey
 	value      []byte
 	generation int64
+	ref        []byte // Cached nodeRef; nil if the leaf has changed.
+	stored     bool   // Unchanged since it was committed or loaded; see isStored.
 }
 
 func (leaf *leafNode) encode() []byte {
@@ -34,14 +78,10 @@ func (leaf *leafNode) encode() []byte {
 }
 
 func (leaf *leafNode) hash(rf bool) []byte {
-	buf := leaf.encode()
-	if rf {
-		return keccak256(buf)
-	}
-	if len(buf) < 32 {
-		return buf
+	if leaf.ref == nil {
+		leaf.ref = nodeRef(leaf.encode())
 	}
-	return encodeBytes(nil, keccak256(buf))
+	return refHash(leaf.ref, rf)
 }
 
 func (leaf *leafNode) toString(w io.Writer, depth int) {
@@ -57,27 +97,41 @@ func (mpt *MPTrie) newLeafNode(sk nibbleKey, val []byte) node {
 	}
 }
 
+// mutableLeafNode returns a leaf which may be changed by the current generation of the trie,
+// copying it if it belongs to an older generation. The leaf is marked as changed because the
+// caller is about to change it; the same is true for the other mutable*Node methods.
+func (mpt *MPTrie) mutableLeafNode(leaf *leafNode) *leafNode {
+	if leaf.generation == mpt.generation {
+		leaf.ref = nil
+		leaf.stored = false
+		return leaf
+	}
+
+	clone := *leaf
+	clone.generation = mpt.generation
+	clone.ref = nil
+	clone.stored = false
+	return &clone
+}
+
 type extensionNode struct {
 	subKey     nibbleKey
-	child      *branchNode // Child will always be a branch node.
+	child      node // Child will always be a branch node or a hash of one.
 	generation int64
+	ref        []byte
+	stored     bool
 }
 
 func (extension *extensionNode) encode() []byte {
 	return encodeTuple(nil, encodeBytes(nil, encodeHexPrefix(extension.subKey, false)),
-		extension.child.encode())
+		extension.child.hash(false))
 }
 
 func (extension *extensionNode) hash(rf bool) []byte {
-	buf := encodeTuple(nil, encodeBytes(nil, encodeHexPrefix(extension.subKey, false)),
-		extension.child.hash(false))
-	if rf {
-		return keccak256(buf)
+	if extension.ref == nil {
+		extension.ref = nodeRef(extension.encode())
 	}
-	if len(buf) < 32 {
-		return buf
-	}
-	return encodeBytes(nil, keccak256(buf))
+	return refHash(extension.ref, rf)
 }
 
 func (extension *extensionNode) toString(w io.Writer, depth int) {
@@ -94,10 +148,26 @@ func (mpt *MPTrie) newExtensionNode(sk nibbleKey) *extensionNode {
 	}
 }
 
+func (mpt *MPTrie) mutableExtensionNode(extension *extensionNode) *extensionNode {
+	if extension.generation == mpt.generation {
+		extension.ref = nil
+		extension.stored = false
+		return extension
+	}
+
+	clone := *extension
+	clone.generation = mpt.generation
+	clone.ref = nil
+	clone.stored = false
+	return &clone
+}
+
 type branchNode struct {
 	children   [16]node
 	value      []byte
 	generation int64
+	ref        []byte
+	stored     bool
 }
 
 func (branch *branchNode) noChildren() bool {
@@ -130,41 +200,23 @@ func (branch *branchNode) encode() []byte {
 		if branch.children[ci] == nil {
 			tuple[ci] = emptyBytes
 		} else {
-			tuple[ci] = branch.children[ci].encode()
+			tuple[ci] = branch.children[ci].hash(false)
 		}
 	}
 	if branch.value == nil {
 		tuple[16] = emptyBytes
 	} else {
-		tuple[16] = branch.value
+		tuple[16] = encodeBytes(nil, branch.value)
 	}
 
 	return encodeTuple(nil, tuple...)
 }
 
 func (branch *branchNode) hash(rf bool) []byte {
-	tuple := make([][]byte, 17)
-	for ci := range branch.children {
-		if branch.children[ci] == nil {
-			tuple[ci] = emptyBytes
-		} else {
-			tuple[ci] = branch.children[ci].hash(false)
-		}
-	}
-	if branch.value == nil {
-		tuple[16] = emptyBytes
-	} else {
-		tuple[16] = encodeBytes(nil, branch.value)
+	if branch.ref == nil {
+		branch.ref = nodeRef(branch.encode())
 	}
-
-	buf := encodeTuple(nil, tuple...)
-	if rf {
-		return keccak256(buf)
-	}
-	if len(buf) < 32 {
-		return buf
-	}
-	return encodeBytes(nil, keccak256(buf))
+	return refHash(branch.ref, rf)
 }
 
 func (branch *branchNode) toString(w io.Writer, depth int) {
@@ -185,6 +237,8 @@ func (branch *branchNode) toString(w io.Writer, depth int) {
 			} else if _, ok := n.(*branchNode); ok {
 				fmt.Fprintf(w, "[%x]\n", idx)
 				n.toString(w, depth+1)
+			} else if hn, ok := n.(hashNode); ok {
+				fmt.Fprintf(w, "[%x] <%x>\n", idx, []byte(hn))
 			} else {
 				panic(fmt.Sprintf("unexpected mptrie node: %#v", n))
 			}
@@ -197,3 +251,138 @@ func (mpt *MPTrie) newBranchNode() *branchNode {
 		generation: mpt.generation,
 	}
 }
+
+func (mpt *MPTrie) mutableBranchNode(branch *branchNode) *branchNode {
+	if branch.generation == mpt.generation {
+		branch.ref = nil
+		branch.stored = false
+		return branch
+	}
+
+	clone := *branch
+	clone.generation = mpt.generation
+	clone.ref = nil
+	clone.stored = false
+	return &clone
+}
+
+// encodeNested returns the encoding of n with its children included in full rather than
+// referred to by hash.
+func encodeNested(n node) []byte {
+	if leaf, ok := n.(*leafNode); ok {
+		return leaf.encode()
+	} else if extension, ok := n.(*extensionNode); ok {
+		return encodeTuple(nil, encodeBytes(nil, encodeHexPrefix(extension.subKey, false)),
+			encodeNested(extension.child))
+	} else if branch, ok := n.(*branchNode); ok {
+		tuple := make([][]byte, 17)
+		for ci, child := range branch.children {
+			if child == nil {
+				tuple[ci] = emptyBytes
+			} else {
+				tuple[ci] = encodeNested(child)
+			}
+		}
+		if branch.value == nil {
+			tuple[16] = emptyBytes
+		} else {
+			tuple[16] = encodeBytes(nil, branch.value)
+		}
+		return encodeTuple(nil, tuple...)
+	} else if hn, ok := n.(hashNode); ok {
+		return hn.hash(false)
+	}
+	panic(fmt.Sprintf("unexpected mptrie node: %#v", n))
+}
+
+func decodeNode(buf []byte) (node, error) {
+	item, err := decodeRLP(buf)
+	if err != nil {
+		return nil, fmt.Errorf("mptrie: bad node: %w", err)
+	}
+	return decodeNodeItem(item)
+}
+
+func decodeNodeItem(item rlpItem) (node, error) {
+	if !item.list {
+		return nil, fmt.Errorf("mptrie: bad node: not a list: %x", item.raw)
+	}
+
+	if len(item.items) == 17 {
+		branch := &branchNode{
+			generation: decodedGeneration,
+		}
+		for ci := range branch.children {
+			n, err := decodeNodeRef(item.items[ci])
+			if err != nil {
+				return nil, err
+			}
+			branch.children[ci] = n
+		}
+
+		if item.items[16].list {
+			return nil, fmt.Errorf("mptrie: bad node: branch value is a list: %x", item.raw)
+		} else if len(item.items[16].str) > 0 {
+			branch.value = item.items[16].str
+		}
+		return branch, nil
+	} else if len(item.items) != 2 {
+		return nil, fmt.Errorf("mptrie: bad node: %d items: %x", len(item.items), item.raw)
+	}
+
+	if item.items[0].list {
+		return nil, fmt.Errorf("mptrie: bad node: key is a list: %x", item.raw)
+	}
+	nk, tf, err := decodeHexPrefix(item.items[0].str)
+	if err != nil {
+		return nil, err
+	}
+
+	if tf {
+		if item.items[1].list {
+			return nil, fmt.Errorf("mptrie: bad node: leaf value is a list: %x", item.raw)
+		}
+		return &leafNode{
+			suffixKey:  nk,
+			value:      item.items[1].str,
+			generation: decodedGeneration,
+		}, nil
+	}
+
+	if len(nk) == 0 {
+		return nil, fmt.Errorf("mptrie: bad node: extension with an empty key: %x", item.raw)
+	}
+	n, err := decodeNodeRef(item.items[1])
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := n.(*branchNode); !ok {
+		if _, ok := n.(hashNode); !ok {
+			return nil, fmt.Errorf("mptrie: bad node: extension child is not a branch: %x",
+				item.raw)
+		}
+	}
+	return &extensionNode{
+		subKey:     nk,
+		child:      n,
+		generation: decodedGeneration,
+	}, nil
+}
+
+// decodeNodeRef decodes a reference to a child node: either an empty string, the hash of the
+// child, or the child itself if its encoding is less than 32 bytes.
+func decodeNodeRef(item rlpItem) (node, error) {
+	if item.list {
+		if len(item.raw) >= 32 {
+			return nil, fmt.Errorf("mptrie: bad node: child of %d bytes is not hashed",
+				len(item.raw))
+		}
+		return decodeNodeItem(item)
+	} else if len(item.str) == 0 {
+		return nil, nil
+	} else if len(item.str) == 32 {
+		return hashNode(item.str), nil
+	}
+
+	return nil, fmt.Errorf("mptrie: bad node: bad child reference: %x", item.raw)
+}
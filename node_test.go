@@ -30,3 +30,126 @@ func TestKeccak256(t *testing.T) {
 		}
 	}
 }
+
+func TestHashCaching(t *testing.T) {
+	mpt := New()
+	for i := 0; i < 256; i++ {
+		err := mpt.Put([]byte{byte(i), 0x12, 0x34}, []byte{byte(i), 0x56, 0x78, 0x9A})
+		if err != nil {
+			t.Fatalf("Put(%d) failed with %s", i, err)
+		}
+	}
+	h := mpt.Hash()
+
+	err := mpt.Put([]byte{0x5A, 0x12, 0x34}, []byte("a new value for this key"))
+	if err != nil {
+		t.Fatalf("Put() failed with %s", err)
+	}
+
+	root, ok := mpt.root.(*branchNode)
+	if !ok {
+		t.Fatalf("root: got %#v, want branch node", mpt.root)
+	}
+	if root.ref != nil {
+		t.Error("root.ref was not cleared")
+	}
+	for ci, n := range root.children {
+		branch, ok := n.(*branchNode)
+		if !ok {
+			t.Fatalf("root.children[%d]: got %#v, want branch node", ci, n)
+		}
+		if ci == 5 {
+			if branch.ref != nil {
+				t.Errorf("root.children[%d].ref was not cleared", ci)
+			}
+		} else if branch.ref == nil {
+			t.Errorf("root.children[%d].ref was cleared", ci)
+		}
+	}
+
+	if bytes.Equal(mpt.Hash(), h) {
+		t.Error("Hash() did not change")
+	}
+	err = mpt.Put([]byte{0x5A, 0x12, 0x34}, []byte{0x5A, 0x56, 0x78, 0x9A})
+	if err != nil {
+		t.Fatalf("Put() failed with %s", err)
+	}
+	if !bytes.Equal(mpt.Hash(), h) {
+		t.Errorf("Hash(): got %v, want %v", mpt.Hash(), h)
+	}
+}
+
+func testDecodeNode(t *testing.T, n node) {
+	t.Helper()
+
+	buf := n.encode()
+	dn, err := decodeNode(buf)
+	if err != nil {
+		t.Errorf("decodeNode(%v) failed with %s", buf, err)
+		return
+	}
+	if !bytes.Equal(dn.encode(), buf) {
+		t.Errorf("decodeNode(%v).encode(): got %v", buf, dn.encode())
+	}
+	if !bytes.Equal(dn.hash(true), n.hash(true)) {
+		t.Errorf("decodeNode(%v).hash(): got %v, want %v", buf, dn.hash(true), n.hash(true))
+	}
+
+	if branch, ok := n.(*branchNode); ok {
+		for ci, child := range branch.children {
+			if child == nil {
+				continue
+			}
+
+			dc := dn.(*branchNode).children[ci]
+			if len(child.hash(false)) >= 32 {
+				if _, ok := dc.(hashNode); !ok {
+					t.Errorf("decodeNode(%v): child %d: got %#v, want hash node", buf, ci, dc)
+				}
+				testDecodeNode(t, child)
+			} else if _, ok := dc.(hashNode); ok {
+				t.Errorf("decodeNode(%v): child %d: got hash node", buf, ci)
+			}
+		}
+	} else if extension, ok := n.(*extensionNode); ok {
+		if len(extension.child.hash(false)) >= 32 {
+			testDecodeNode(t, extension.child)
+		}
+	}
+}
+
+func TestDecodeNode(t *testing.T) {
+	mpt := New()
+	for i := 0; i < 128; i++ {
+		err := mpt.Put([]byte{byte(i * 2), 0x12, 0x34, byte(i)}, bytes.Repeat([]byte{0xAB}, i%40))
+		if err != nil {
+			t.Fatalf("Put(%d) failed with %s", i, err)
+		}
+	}
+	for _, key := range [][]byte{{0x10}, {0xF1, 0x23, 0x45, 0x01}, {0xF1, 0x23, 0x45, 0x02}} {
+		err := mpt.Put(key, bytes.Repeat([]byte{0xCD}, 32))
+		if err != nil {
+			t.Fatalf("Put(%v) failed with %s", key, err)
+		}
+	}
+	testDecodeNode(t, mpt.root)
+
+	cases := [][]byte{
+		{0x80},
+		{0xC0},
+		{0xC3, 0x80, 0x80, 0x80},
+		{0xC2, 0xC0, 0x80},
+		{0xC2, 0x40, 0x80},
+		{0xC2, 0x00, 0x80},
+		{0xC3, 0x20, 0xC1, 0x80},
+		{0xC4, 0x11, 0x82, 0x01, 0x02},
+		{0xC3, 0x11, 0xC1, 0x80},
+		{0xC2, 0x11, 0x80, 0x80},
+	}
+	for _, buf := range cases {
+		n, err := decodeNode(buf)
+		if err == nil {
+			t.Errorf("decodeNode(%v): got %#v, want error", buf, n)
+		}
+	}
+}
@@ -0,0 +1,554 @@
+package mptrie
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrNoDiffs = errors.New("mptrie: no diffs to roll back")
+)
+
+// KeyValueStore is the storage used by a PathStore. Get must return ErrNotFound if there is no
+// value for key.
+type KeyValueStore interface {
+	Get(key []byte) ([]byte, error)
+	Put(key, val []byte) error
+	Delete(key []byte) error
+}
+
+type MemoryKeyValueStore struct {
+	vals map[string][]byte
+}
+
+func NewMemoryKeyValueStore() *MemoryKeyValueStore {
+	return &MemoryKeyValueStore{
+		vals: map[string][]byte{},
+	}
+}
+
+func (mkv *MemoryKeyValueStore) Get(key []byte) ([]byte, error) {
+	val, ok := mkv.vals[string(key)]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return val, nil
+}
+
+func (mkv *MemoryKeyValueStore) Put(key, val []byte) error {
+	mkv.vals[string(key)] = append([]byte(nil), val...)
+	return nil
+}
+
+func (mkv *MemoryKeyValueStore) Delete(key []byte) error {
+	delete(mkv.vals, string(key))
+	return nil
+}
+
+// The keys of a PathStore: 'n', the length of the owner, the owner and the path of a node;
+// 'd' and the big endian sequence number of a diff; and 'm' for the sequence numbers of the
+// oldest and next diffs and the number of nodes, each 8 bytes big endian.
+var (
+	pathMetaKey = []byte{'m'}
+)
+
+func pathNodeKey(owner []byte, path nibbleKey) []byte {
+	key := append([]byte{'n', byte(len(owner))}, owner...)
+	return append(key, path...)
+}
+
+func pathDiffKey(seq uint64) []byte {
+	key := []byte{'d', 0, 0, 0, 0, 0, 0, 0, 0}
+	binary.BigEndian.PutUint64(key[1:], seq)
+	return key
+}
+
+type pathDiff struct {
+	owner []byte
+	root  []byte            // The root of the owner's trie before the commit.
+	nodes map[string][]byte // The nodes before the commit by path; nil if there was no node.
+}
+
+// encode returns a list of the owner, the root, and a list of the nodes, each a list of the
+// path and, if there was a node, the node.
+func (diff *pathDiff) encode() []byte {
+	var nodes [][]byte
+	for path, buf := range diff.nodes {
+		if buf == nil {
+			nodes = append(nodes, encodeTuple(nil, encodeBytes(nil, []byte(path))))
+		} else {
+			nodes = append(nodes, encodeTuple(nil, encodeBytes(nil, []byte(path)),
+				encodeBytes(nil, buf)))
+		}
+	}
+	return encodeTuple(nil, encodeBytes(nil, diff.owner), encodeBytes(nil, diff.root),
+		encodeTuple(nil, nodes...))
+}
+
+func decodePathDiff(buf []byte) (*pathDiff, error) {
+	item, err := decodeRLP(buf)
+	if err != nil {
+		return nil, err
+	} else if !item.list || len(item.items) != 3 || item.items[0].list ||
+		item.items[1].list || !item.items[2].list {
+		return nil, fmt.Errorf("mptrie: bad path diff: %x", buf)
+	}
+
+	diff := &pathDiff{
+		owner: item.items[0].str,
+		root:  item.items[1].str,
+		nodes: map[string][]byte{},
+	}
+	for _, it := range item.items[2].items {
+		if !it.list || len(it.items) < 1 || len(it.items) > 2 || it.items[0].list {
+			return nil, fmt.Errorf("mptrie: bad path diff: %x", buf)
+		}
+		var nbuf []byte
+		if len(it.items) == 2 {
+			if it.items[1].list {
+				return nil, fmt.Errorf("mptrie: bad path diff: %x", buf)
+			}
+			nbuf = it.items[1].str
+		}
+		diff.nodes[string(it.items[0].str)] = nbuf
+	}
+	return diff, nil
+}
+
+// PathStore stores nodes by their owner and the path from the root of the owner's trie to the
+// node, rather than by hash, so only the latest version of each node is kept. The owner is
+// empty for an account trie, and the hash of the account for a storage trie. Each commit
+// records a reverse diff, and the last limit commits can be rolled back.
+//
+// The nodes and diffs are kept in a KeyValueStore. The diff of a commit is written before its
+// nodes, so opening the PathStore again undoes an interrupted commit, and finishes an
+// interrupted rollback.
+type PathStore struct {
+	kv    KeyValueStore
+	limit int
+	first uint64 // The sequence number of the oldest diff.
+	next  uint64 // The sequence number of the next diff.
+	count int
+}
+
+// NewPathStore opens the PathStore kept in kv, which is empty for a new PathStore.
+func NewPathStore(kv KeyValueStore, limit int) (*PathStore, error) {
+	ps := &PathStore{
+		kv:    kv,
+		limit: limit,
+	}
+
+	buf, err := kv.Get(pathMetaKey)
+	if err == nil {
+		err = ps.decodeMeta(buf)
+		if err != nil {
+			return nil, err
+		}
+	} else if err != ErrNotFound {
+		return nil, err
+	}
+
+	// Undo an interrupted commit, or finish an interrupted rollback.
+	buf, err = kv.Get(pathDiffKey(ps.next))
+	if err == ErrNotFound {
+		return ps, nil
+	} else if err != nil {
+		return nil, err
+	}
+	diff, err := decodePathDiff(buf)
+	if err != nil {
+		return nil, err
+	}
+	err = ps.apply(diff.owner, diff.nodes)
+	if err != nil {
+		return nil, err
+	}
+	err = kv.Delete(pathDiffKey(ps.next))
+	if err != nil {
+		return nil, err
+	}
+	return ps, nil
+}
+
+func (ps *PathStore) decodeMeta(buf []byte) error {
+	if len(buf) != 24 {
+		return fmt.Errorf("mptrie: bad path store: %x", buf)
+	}
+	first := binary.BigEndian.Uint64(buf)
+	next := binary.BigEndian.Uint64(buf[8:])
+	if first > next {
+		return fmt.Errorf("mptrie: bad path store: %x", buf)
+	}
+	ps.first, ps.next, ps.count = first, next, int(binary.BigEndian.Uint64(buf[16:]))
+	return nil
+}
+
+func (ps *PathStore) putMeta(first, next uint64, count int) error {
+	buf := make([]byte, 24)
+	binary.BigEndian.PutUint64(buf, first)
+	binary.BigEndian.PutUint64(buf[8:], next)
+	binary.BigEndian.PutUint64(buf[16:], uint64(count))
+	err := ps.kv.Put(pathMetaKey, buf)
+	if err != nil {
+		return err
+	}
+	ps.first, ps.next, ps.count = first, next, count
+	return nil
+}
+
+// node returns the node of the owner at path, or nil if there is none.
+func (ps *PathStore) node(owner []byte, path nibbleKey) ([]byte, error) {
+	buf, err := ps.kv.Get(pathNodeKey(owner, path))
+	if err == ErrNotFound {
+		return nil, nil
+	}
+	return buf, err
+}
+
+func (ps *PathStore) get(owner []byte, path nibbleKey, hash []byte) ([]byte, error) {
+	buf, err := ps.node(owner, path)
+	if err != nil {
+		return nil, err
+	} else if buf == nil || !bytes.Equal(keccak256(buf), hash) {
+		return nil, ErrNotFound
+	}
+	return buf, nil
+}
+
+// apply writes the nodes of the owner by path, and deletes those which are nil.
+func (ps *PathStore) apply(owner []byte, nodes map[string][]byte) error {
+	for path, buf := range nodes {
+		var err error
+		if buf == nil {
+			err = ps.kv.Delete(pathNodeKey(owner, nibbleKey(path)))
+		} else {
+			err = ps.kv.Put(pathNodeKey(owner, nibbleKey(path)), buf)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// countChanges returns the number of nodes after the nodes of the owner are applied.
+func (ps *PathStore) countChanges(owner []byte, nodes map[string][]byte) (int, error) {
+	count := ps.count
+	for path, buf := range nodes {
+		cur, err := ps.node(owner, nibbleKey(path))
+		if err != nil {
+			return 0, err
+		}
+		if cur == nil && buf != nil {
+			count += 1
+		} else if cur != nil && buf == nil {
+			count -= 1
+		}
+	}
+	return count, nil
+}
+
+// Root returns the root hash of the owner's trie.
+func (ps *PathStore) Root(owner []byte) ([]byte, error) {
+	buf, err := ps.node(owner, nil)
+	if err != nil {
+		return nil, err
+	} else if buf == nil {
+		return emptyHash, nil
+	}
+	return keccak256(buf), nil
+}
+
+// Len returns the number of nodes in the store.
+func (ps *PathStore) Len() int {
+	return ps.count
+}
+
+// Rollback undoes the last commit which has not been rolled back, and returns the root hash
+// of the owner's trie before that commit. Tries which were committed to ps may still be used
+// and committed again; see CommitPath.
+func (ps *PathStore) Rollback() ([]byte, error) {
+	if ps.next == ps.first {
+		return nil, ErrNoDiffs
+	}
+
+	seq := ps.next - 1
+	buf, err := ps.kv.Get(pathDiffKey(seq))
+	if err != nil {
+		return nil, fmt.Errorf("mptrie: path diff %d: %w", seq, err)
+	}
+	diff, err := decodePathDiff(buf)
+	if err != nil {
+		return nil, err
+	}
+	count, err := ps.countChanges(diff.owner, diff.nodes)
+	if err != nil {
+		return nil, err
+	}
+	// Once the diff is past the last commit, opening the store again finishes the rollback.
+	err = ps.putMeta(ps.first, seq, count)
+	if err != nil {
+		return nil, err
+	}
+	err = ps.apply(diff.owner, diff.nodes)
+	if err != nil {
+		return nil, err
+	}
+	err = ps.kv.Delete(pathDiffKey(seq))
+	if err != nil {
+		return nil, err
+	}
+	return diff.root, nil
+}
+
+// commit writes the nodes of the owner by path, deletes those which are nil, and records the
+// reverse diff.
+func (ps *PathStore) commit(owner []byte, nodes map[string][]byte) error {
+	root, err := ps.Root(owner)
+	if err != nil {
+		return err
+	}
+	diff := &pathDiff{
+		owner: owner,
+		root:  root,
+		nodes: map[string][]byte{},
+	}
+	for path := range nodes {
+		diff.nodes[path], err = ps.node(owner, nibbleKey(path))
+		if err != nil {
+			return err
+		}
+	}
+	count, err := ps.countChanges(owner, nodes)
+	if err != nil {
+		return err
+	}
+
+	err = ps.kv.Put(pathDiffKey(ps.next), diff.encode())
+	if err != nil {
+		return err
+	}
+	err = ps.apply(owner, nodes)
+	if err != nil {
+		return err
+	}
+
+	first, next := ps.first, ps.next+1
+	if next-first > uint64(ps.limit) {
+		first = next - uint64(ps.limit)
+	}
+	prev := ps.first
+	err = ps.putMeta(first, next, count)
+	if err != nil {
+		return err
+	}
+	for seq := prev; seq < first; seq++ {
+		err = ps.kv.Delete(pathDiffKey(seq))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// OpenPath returns the owner's trie with the given root hash whose nodes are loaded from ps
+// as they are needed.
+func OpenPath(root []byte, ps *PathStore, owner []byte) (*MPTrie, error) {
+	mpt, err := Open(root, nil)
+	if err != nil {
+		return nil, err
+	}
+	mpt.pathStore = ps
+	mpt.owner = append([]byte(nil), owner...)
+	return mpt, nil
+}
+
+type pathCommit struct {
+	mpt        *MPTrie
+	ps         *PathStore
+	owner      []byte
+	writes     map[string][]byte   // The new nodes by path; nil to delete the node.
+	live       map[string]struct{} // The paths of the stored nodes of the new trie.
+	candidates []nibbleKey         // Paths which might no longer have a stored node.
+	visited    map[string]struct{}
+}
+
+// CommitPath writes every node which is not already at its path in ps, and whose encoding is
+// at least 32 bytes, to ps by path; the root is always written. Nodes which are no longer
+// part of the trie are removed. The trie may have been committed elsewhere, or ps may have
+// been rolled back or committed from another trie since; nodes missing from ps are written
+// again. Since ps only keeps the latest version of the owner's trie, nodes which the trie has
+// not loaded must still be in that version, or CommitPath fails with a MissingNodeError.
+func (mpt *MPTrie) CommitPath(ps *PathStore, owner []byte) ([]byte, error) {
+	pc := &pathCommit{
+		mpt:     mpt,
+		ps:      ps,
+		owner:   owner,
+		writes:  map[string][]byte{},
+		live:    map[string]struct{}{},
+		visited: map[string]struct{}{},
+	}
+
+	if mpt.root == nil {
+		pc.candidates = append(pc.candidates, nibbleKey{})
+	} else {
+		err := pc.commitNode(mpt.root, nibbleKey{}, true)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, path := range pc.candidates {
+		err := pc.deleteStale(path)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	owner = append([]byte(nil), owner...)
+	err := ps.commit(owner, pc.writes)
+	if err != nil {
+		return nil, err
+	}
+
+	mpt.pathStore = ps
+	mpt.owner = owner
+	return mpt.Hash(), nil
+}
+
+func (pc *pathCommit) commitNode(n node, path nibbleKey, root bool) error {
+	if hn, ok := n.(hashNode); ok {
+		has, err := pc.hasNode(path, hn)
+		if err != nil {
+			return err
+		} else if has {
+			pc.live[string(path)] = struct{}{}
+			return nil
+		}
+
+		// Copy the node, and the nodes below it, from the trie's own store without keeping
+		// them in the trie.
+		n, err = pc.mpt.resolve(hn, path)
+		if err != nil {
+			return err
+		}
+	} else if isStored(n) {
+		if !root && len(n.hash(false)) < 32 {
+			// Small nodes are included in their parent, and so are the nodes below them.
+			return nil
+		}
+
+		// If ps has the node at path, it has the nodes below it as well.
+		has, err := pc.hasNode(path, n.hash(true))
+		if err != nil {
+			return err
+		} else if has {
+			pc.live[string(path)] = struct{}{}
+			return nil
+		}
+	}
+
+	if branch, ok := n.(*branchNode); ok {
+		for ci, child := range branch.children {
+			if child != nil {
+				err := pc.commitNode(child, joinNibbleKeys(path, nibbleKey{byte(ci)}), false)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	} else if extension, ok := n.(*extensionNode); ok {
+		err := pc.commitNode(extension.child, joinNibbleKeys(path, extension.subKey), false)
+		if err != nil {
+			return err
+		}
+	} else if _, ok := n.(*leafNode); !ok {
+		panic(fmt.Sprintf("unexpected mptrie node: %#v", n))
+	}
+
+	if !root && len(n.hash(false)) < 32 {
+		// Small nodes are included in their parent.
+		markStored(n)
+		return nil
+	}
+
+	pc.live[string(path)] = struct{}{}
+	pc.writes[string(path)] = n.encode()
+	buf, err := pc.ps.node(pc.owner, path)
+	if err != nil {
+		return err
+	} else if buf != nil {
+		paths, err := storedChildPaths(buf, path)
+		if err != nil {
+			return err
+		}
+		pc.candidates = append(pc.candidates, paths...)
+	}
+	markStored(n)
+	return nil
+}
+
+// hasNode returns whether the node with hash is at path in ps.
+func (pc *pathCommit) hasNode(path nibbleKey, hash []byte) (bool, error) {
+	buf, err := pc.ps.node(pc.owner, path)
+	if err != nil {
+		return false, err
+	}
+	return buf != nil && bytes.Equal(keccak256(buf), hash), nil
+}
+
+// deleteStale deletes the node at path, and the nodes below it, unless they are part of the
+// new trie.
+func (pc *pathCommit) deleteStale(path nibbleKey) error {
+	if _, ok := pc.live[string(path)]; ok {
+		return nil
+	}
+	if _, ok := pc.visited[string(path)]; ok {
+		return nil
+	}
+	pc.visited[string(path)] = struct{}{}
+
+	buf, err := pc.ps.node(pc.owner, path)
+	if err != nil || buf == nil {
+		return err
+	}
+
+	paths, err := storedChildPaths(buf, path)
+	if err != nil {
+		return err
+	}
+	for _, cp := range paths {
+		err = pc.deleteStale(cp)
+		if err != nil {
+			return err
+		}
+	}
+
+	pc.writes[string(path)] = nil
+	return nil
+}
+
+// storedChildPaths returns the paths of the children of the node, encoded in buf at path,
+// which are stored separately, ie. referenced by hash.
+func storedChildPaths(buf []byte, path nibbleKey) ([]nibbleKey, error) {
+	n, err := decodeNode(buf)
+	if err != nil {
+		return nil, err
+	}
+
+	var paths []nibbleKey
+	if branch, ok := n.(*branchNode); ok {
+		for ci, child := range branch.children {
+			if _, ok := child.(hashNode); ok {
+				paths = append(paths, joinNibbleKeys(path, nibbleKey{byte(ci)}))
+			}
+		}
+	} else if extension, ok := n.(*extensionNode); ok {
+		if _, ok := extension.child.(hashNode); ok {
+			paths = append(paths, joinNibbleKeys(path, extension.subKey))
+		}
+	}
+	return paths, nil
+}
@@ -0,0 +1,343 @@
+package mptrie
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func countStoredNodes(n node, root bool) int {
+	if n == nil {
+		return 0
+	}
+
+	cnt := 0
+	if root || len(n.hash(false)) >= 32 {
+		cnt = 1
+	}
+	if branch, ok := n.(*branchNode); ok {
+		for _, child := range branch.children {
+			cnt += countStoredNodes(child, false)
+		}
+	} else if extension, ok := n.(*extensionNode); ok {
+		cnt += countStoredNodes(extension.child, false)
+	}
+	return cnt
+}
+
+func checkPathTrie(t *testing.T, ps *PathStore, owner []byte, want *MPTrie) {
+	t.Helper()
+
+	mpt, err := OpenPath(want.Hash(), ps, owner)
+	if err != nil {
+		t.Fatalf("OpenPath() failed with %s", err)
+	}
+
+	it := want.Iterator(nil)
+	pit := mpt.Iterator(nil)
+	for it.Next() {
+		if !pit.Next() {
+			t.Errorf("Iterator(): got end, want %v", it.Key())
+			break
+		}
+		if !bytes.Equal(pit.Key(), it.Key()) || !bytes.Equal(pit.Value(), it.Value()) {
+			t.Errorf("Iterator(): got %v = %v, want %v = %v", pit.Key(), pit.Value(), it.Key(),
+				it.Value())
+		}
+	}
+	if pit.Next() {
+		t.Errorf("Iterator(): got %v, want end", pit.Key())
+	}
+	if pit.Err() != nil {
+		t.Errorf("Iterator() failed with %s", pit.Err())
+	}
+}
+
+func TestPathStore(t *testing.T) {
+	ps, err := NewPathStore(NewMemoryKeyValueStore(), 3)
+	if err != nil {
+		t.Fatalf("NewPathStore() failed with %s", err)
+	}
+	owner := []byte("owner")
+
+	var roots [][]byte
+	var versions []*MPTrie
+	mpt := New()
+	for v := 0; v < 6; v++ {
+		for i := v * 40; i < v*40+100; i++ {
+			err := mpt.Put(testKey(i), append(testValue(i), byte(v)))
+			if err != nil {
+				t.Fatalf("Put(%d) failed with %s", i, err)
+			}
+		}
+		for i := v * 40; i < v*40+20; i++ {
+			err := mpt.Delete(testKey(i))
+			if err != nil && err != ErrNotFound {
+				t.Fatalf("Delete(%d) failed with %s", i, err)
+			}
+		}
+
+		root, err := mpt.CommitPath(ps, owner)
+		if err != nil {
+			t.Fatalf("CommitPath() failed with %s", err)
+		}
+		if psr, err := ps.Root(owner); err != nil {
+			t.Errorf("Root() failed with %s", err)
+		} else if !bytes.Equal(psr, root) {
+			t.Errorf("Root(): got %x, want %x", psr, root)
+		}
+		if cnt := countStoredNodes(mpt.root, true); ps.Len() != cnt {
+			t.Errorf("CommitPath(): got %d nodes, want %d", ps.Len(), cnt)
+		}
+		checkPathTrie(t, ps, owner, mpt)
+
+		roots = append(roots, root)
+		versions = append(versions, mpt)
+		mpt = mpt.Clone()
+	}
+
+	for v := 4; v >= 2; v-- {
+		root, err := ps.Rollback()
+		if err != nil {
+			t.Fatalf("Rollback() failed with %s", err)
+		} else if !bytes.Equal(root, roots[v]) {
+			t.Errorf("Rollback(): got %x, want %x", root, roots[v])
+		}
+		if cnt := countStoredNodes(versions[v].root, true); ps.Len() != cnt {
+			t.Errorf("Rollback(): got %d nodes, want %d", ps.Len(), cnt)
+		}
+		checkPathTrie(t, ps, owner, versions[v])
+	}
+
+	_, err = ps.Rollback()
+	if err != ErrNoDiffs {
+		t.Errorf("Rollback(): got %v, want %s", err, ErrNoDiffs)
+	}
+
+	mpt, err = OpenPath(roots[2], ps, owner)
+	if err != nil {
+		t.Fatalf("OpenPath() failed with %s", err)
+	}
+	for i := 0; i < 400; i++ {
+		err := mpt.Delete(testKey(i))
+		if err != nil && err != ErrNotFound {
+			t.Fatalf("Delete(%d) failed with %s", i, err)
+		}
+	}
+	root, err := mpt.CommitPath(ps, owner)
+	if err != nil {
+		t.Fatalf("CommitPath() failed with %s", err)
+	} else if !bytes.Equal(root, emptyHash) || ps.Len() != 0 {
+		t.Errorf("CommitPath(): got %x and %d nodes, want empty", root, ps.Len())
+	}
+
+	_, err = ps.Rollback()
+	if err != nil {
+		t.Fatalf("Rollback() failed with %s", err)
+	}
+	checkPathTrie(t, ps, owner, versions[2])
+
+	mpt, err = OpenPath(roots[2], ps, []byte("other"))
+	if err != nil {
+		t.Fatalf("OpenPath() failed with %s", err)
+	}
+	if _, err := mpt.Get(testKey(50)); err == nil {
+		t.Error("Get() with another owner did not fail")
+	}
+}
+
+func TestPathStoreRollbackTrie(t *testing.T) {
+	ps, err := NewPathStore(NewMemoryKeyValueStore(), 3)
+	if err != nil {
+		t.Fatalf("NewPathStore() failed with %s", err)
+	}
+	owner := []byte("owner")
+
+	mpt := New()
+	for v := 0; v < 3; v++ {
+		for i := v * 50; i < v*50+200; i++ {
+			err := mpt.Put(testKey(i), append(testValue(i), byte(v)))
+			if err != nil {
+				t.Fatalf("Put(%d) failed with %s", i, err)
+			}
+		}
+		_, err := mpt.CommitPath(ps, owner)
+		if err != nil {
+			t.Fatalf("CommitPath() failed with %s", err)
+		}
+
+		if v == 1 {
+			// Keep using the trie after its last commit has been rolled back.
+			_, err = ps.Rollback()
+			if err != nil {
+				t.Fatalf("Rollback() failed with %s", err)
+			}
+		}
+	}
+
+	omt, err := OpenPath(mpt.Hash(), ps, owner)
+	if err != nil {
+		t.Fatalf("OpenPath() failed with %s", err)
+	}
+	violations, err := omt.Validate()
+	if err != nil {
+		t.Fatalf("Validate() failed with %s", err)
+	} else if len(violations) > 0 {
+		t.Errorf("Validate(): got %v", violations)
+	}
+	checkPathTrie(t, ps, owner, mpt)
+	if cnt := countStoredNodes(mpt.root, true); ps.Len() != cnt {
+		t.Errorf("CommitPath(): got %d nodes, want %d", ps.Len(), cnt)
+	}
+}
+
+type failingKeyValueStore struct {
+	*MemoryKeyValueStore
+	puts int // The number of puts before they fail.
+}
+
+func (fkv *failingKeyValueStore) Put(key, val []byte) error {
+	if fkv.puts == 0 {
+		return errors.New("put failed")
+	}
+	fkv.puts -= 1
+	return fkv.MemoryKeyValueStore.Put(key, val)
+}
+
+func TestPathStoreReopen(t *testing.T) {
+	kv := &failingKeyValueStore{MemoryKeyValueStore: NewMemoryKeyValueStore(), puts: -1}
+	ps, err := NewPathStore(kv, 2)
+	if err != nil {
+		t.Fatalf("NewPathStore() failed with %s", err)
+	}
+	owner := []byte("owner")
+
+	mpt := New()
+	for i := 0; i < 200; i++ {
+		err := mpt.Put(testKey(i), testValue(i))
+		if err != nil {
+			t.Fatalf("Put(%d) failed with %s", i, err)
+		}
+	}
+	root1, err := mpt.CommitPath(ps, owner)
+	if err != nil {
+		t.Fatalf("CommitPath() failed with %s", err)
+	}
+	want := mpt.Clone()
+	cnt := ps.Len()
+
+	// Interrupt the next commit after the diff and some of the nodes are written.
+	for i := 0; i < 100; i++ {
+		err := mpt.Put(testKey(i), append(testValue(i), 0xFF))
+		if err != nil {
+			t.Fatalf("Put(%d) failed with %s", i, err)
+		}
+	}
+	kv.puts = 5
+	_, err = mpt.CommitPath(ps, owner)
+	if err == nil {
+		t.Fatal("CommitPath() did not fail")
+	}
+	kv.puts = -1
+
+	ps, err = NewPathStore(kv, 2)
+	if err != nil {
+		t.Fatalf("NewPathStore() failed with %s", err)
+	}
+	if root, err := ps.Root(owner); err != nil {
+		t.Errorf("Root() failed with %s", err)
+	} else if !bytes.Equal(root, root1) {
+		t.Errorf("Root(): got %x, want %x", root, root1)
+	}
+	if ps.Len() != cnt {
+		t.Errorf("NewPathStore(): got %d nodes, want %d", ps.Len(), cnt)
+	}
+	checkPathTrie(t, ps, owner, want)
+
+	mpt, err = OpenPath(root1, ps, owner)
+	if err != nil {
+		t.Fatalf("OpenPath() failed with %s", err)
+	}
+	for i := 0; i < 100; i++ {
+		err := mpt.Put(testKey(i), append(testValue(i), 0xFF))
+		if err != nil {
+			t.Fatalf("Put(%d) failed with %s", i, err)
+		}
+	}
+	_, err = mpt.CommitPath(ps, owner)
+	if err != nil {
+		t.Fatalf("CommitPath() failed with %s", err)
+	}
+
+	ps, err = NewPathStore(kv, 2)
+	if err != nil {
+		t.Fatalf("NewPathStore() failed with %s", err)
+	}
+	checkPathTrie(t, ps, owner, mpt)
+	root, err := ps.Rollback()
+	if err != nil {
+		t.Fatalf("Rollback() failed with %s", err)
+	} else if !bytes.Equal(root, root1) {
+		t.Errorf("Rollback(): got %x, want %x", root, root1)
+	}
+	checkPathTrie(t, ps, owner, want)
+	if ps.Len() != cnt {
+		t.Errorf("Rollback(): got %d nodes, want %d", ps.Len(), cnt)
+	}
+}
+
+func TestCommitPathStores(t *testing.T) {
+	mpt := New()
+	for i := 0; i < 300; i++ {
+		err := mpt.Put(testKey(i), testValue(i))
+		if err != nil {
+			t.Fatalf("Put(%d) failed with %s", i, err)
+		}
+	}
+	store := NewMemoryStore()
+	root, err := mpt.Commit(store)
+	if err != nil {
+		t.Fatalf("Commit() failed with %s", err)
+	}
+
+	// A trie loaded from a NodeStore, changed, and committed to a PathStore.
+	omt, err := Open(root, store)
+	if err != nil {
+		t.Fatalf("Open() failed with %s", err)
+	}
+	err = omt.Put(testKey(300), testValue(300))
+	if err != nil {
+		t.Fatalf("Put(300) failed with %s", err)
+	}
+	ps, err := NewPathStore(NewMemoryKeyValueStore(), 2)
+	if err != nil {
+		t.Fatalf("NewPathStore() failed with %s", err)
+	}
+	owner := []byte("owner")
+	_, err = omt.CommitPath(ps, owner)
+	if err != nil {
+		t.Fatalf("CommitPath() failed with %s", err)
+	}
+	checkPathTrie(t, ps, owner, omt)
+	err = mpt.Put(testKey(300), testValue(300))
+	if err != nil {
+		t.Fatalf("Put(300) failed with %s", err)
+	}
+	if cnt := countStoredNodes(mpt.root, true); ps.Len() != cnt {
+		t.Errorf("CommitPath(): got %d nodes, want %d", ps.Len(), cnt)
+	}
+
+	// The same trie committed to a PathStore and then to a NodeStore.
+	store2 := NewMemoryStore()
+	err = omt.Put(testKey(301), testValue(301))
+	if err != nil {
+		t.Fatalf("Put(301) failed with %s", err)
+	}
+	root, err = omt.Commit(store2)
+	if err != nil {
+		t.Fatalf("Commit() failed with %s", err)
+	}
+	if cnt := countStoredKeys(t, store2, root); cnt != 302 {
+		t.Errorf("Commit(): got %d keys, want 302", cnt)
+	}
+}
@@ -0,0 +1,209 @@
+package mptrie
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+var (
+	ErrBadProof = errors.New("mptrie: bad proof")
+)
+
+// Prove returns the RLP encoded nodes on the path from the root to key, starting with the
+// root. If key is in the trie, the last node is the one containing the value; otherwise the
+// nodes show where the path to key ends. Nodes which are small enough to be included in their
+// parent are not returned separately.
+func (mpt *MPTrie) Prove(key []byte) ([][]byte, error) {
+	var proof [][]byte
+
+	fk := keyToNibbleKey(key)
+	nk := fk
+	n := mpt.root
+	for n != nil {
+		if hn, ok := n.(hashNode); ok {
+			var err error
+			n, err = mpt.resolve(hn, fk[:len(fk)-len(nk)])
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if len(proof) == 0 || len(n.hash(false)) >= 32 {
+			proof = append(proof, n.encode())
+		}
+
+		if branch, ok := n.(*branchNode); ok {
+			if len(nk) == 0 {
+				break
+			}
+
+			n = branch.children[nk[0]]
+			nk = nk[1:]
+		} else if extension, ok := n.(*extensionNode); ok {
+			l := len(extension.subKey)
+			if len(nk) < l || !bytes.Equal(nk[:l], extension.subKey) {
+				break
+			}
+
+			nk = nk[l:]
+			n = extension.child
+		} else if _, ok := n.(*leafNode); ok {
+			break
+		} else {
+			panic(fmt.Sprintf("unexpected mptrie node: %#v", n))
+		}
+	}
+
+	return proof, nil
+}
+
+// VerifyProof checks proof, as returned by Prove, against the root hash of a trie. It returns
+// the value of key if the proof shows it is in the trie, and ErrNotFound if the proof shows it
+// is not in the trie. Any other error means the proof is malformed or does not match root.
+func VerifyProof(root, key []byte, proof [][]byte) ([]byte, error) {
+	return verifyKey(root, key, proofNodes(proof))
+}
+
+// ProveMulti returns the RLP encoded nodes on the paths from the root to each of keys, with
+// each node included only once. The nodes are in the order they are first visited when the
+// keys are proved in sorted order, so the root is always first.
+func (mpt *MPTrie) ProveMulti(keys [][]byte) ([][]byte, error) {
+	sorted := append([][]byte(nil), keys...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i], sorted[j]) < 0
+	})
+
+	var proof [][]byte
+	seen := map[string]struct{}{}
+	for _, key := range sorted {
+		kp, err := mpt.Prove(key)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, buf := range kp {
+			if _, ok := seen[string(buf)]; !ok {
+				seen[string(buf)] = struct{}{}
+				proof = append(proof, buf)
+			}
+		}
+	}
+
+	return proof, nil
+}
+
+// VerifyMultiProof checks proof, as returned by ProveMulti, against the root hash of a trie,
+// and returns the value of each of keys; the value is nil if the proof shows the key is not in
+// the trie. An error means the proof is malformed or does not match root.
+func VerifyMultiProof(root []byte, keys [][]byte, proof [][]byte) ([][]byte, error) {
+	nodes := proofNodes(proof)
+	values := make([][]byte, len(keys))
+	for ki, key := range keys {
+		val, err := verifyKey(root, key, nodes)
+		if err == nil {
+			values[ki] = val
+		} else if err != ErrNotFound {
+			return nil, err
+		}
+	}
+	return values, nil
+}
+
+// OpenPartial returns a trie with the given root hash which has only nodes, such as the nodes
+// of a proof or a witness; the rest of the trie is left as hashes. Get, Put and Delete fail
+// with a MissingNodeError, and leave the trie unchanged, if they need any other node.
+func OpenPartial(root []byte, nodes [][]byte) (*MPTrie, error) {
+	store := NewMemoryStore()
+	store.nodes = proofNodes(nodes)
+	return Open(root, store)
+}
+
+func proofNodes(proof [][]byte) map[string][]byte {
+	nodes := map[string][]byte{}
+	for _, buf := range proof {
+		nodes[string(keccak256(buf))] = buf
+	}
+	return nodes
+}
+
+// verifyKey walks the path to key from root through nodes, which are indexed by hash.
+func verifyKey(root, key []byte, nodes map[string][]byte) ([]byte, error) {
+	if bytes.Equal(root, emptyHash) {
+		return nil, ErrNotFound
+	}
+
+	nk := keyToNibbleKey(key)
+	ref := rlpItem{raw: encodeBytes(nil, root), str: root}
+	for {
+		var item rlpItem
+		if ref.list {
+			item = ref
+		} else if len(ref.str) == 0 {
+			return nil, ErrNotFound
+		} else if len(ref.str) == 32 {
+			buf, ok := nodes[string(ref.str)]
+			if !ok {
+				return nil, fmt.Errorf("%w: missing node %x", ErrBadProof, ref.str)
+			}
+
+			var err error
+			item, err = decodeRLP(buf)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %s", ErrBadProof, err)
+			} else if !item.list {
+				return nil, fmt.Errorf("%w: node is not a list: %x", ErrBadProof, buf)
+			}
+		} else {
+			return nil, fmt.Errorf("%w: bad node reference %x", ErrBadProof, ref.raw)
+		}
+
+		if len(item.items) == 17 {
+			if len(nk) == 0 {
+				val, err := proofString(item.items[16])
+				if err != nil {
+					return nil, err
+				} else if len(val) == 0 {
+					return nil, ErrNotFound
+				}
+				return val, nil
+			}
+
+			ref = item.items[nk[0]]
+			nk = nk[1:]
+		} else if len(item.items) == 2 {
+			hpk, err := proofString(item.items[0])
+			if err != nil {
+				return nil, err
+			}
+			sk, leaf, err := decodeHexPrefix(hpk)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %s", ErrBadProof, err)
+			}
+
+			if leaf {
+				if !bytes.Equal(nk, sk) {
+					return nil, ErrNotFound
+				}
+				return proofString(item.items[1])
+			}
+
+			l := len(sk)
+			if len(nk) < l || !bytes.Equal(nk[:l], sk) {
+				return nil, ErrNotFound
+			}
+			ref = item.items[1]
+			nk = nk[l:]
+		} else {
+			return nil, fmt.Errorf("%w: node has %d items", ErrBadProof, len(item.items))
+		}
+	}
+}
+
+func proofString(item rlpItem) ([]byte, error) {
+	if item.list {
+		return nil, fmt.Errorf("%w: expected a string: %x", ErrBadProof, item.raw)
+	}
+	return item.str, nil
+}
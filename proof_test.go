@@ -0,0 +1,318 @@
+package mptrie
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestProve(t *testing.T) {
+	mpt := New()
+	proof, err := mpt.Prove([]byte{0x01})
+	if err != nil {
+		t.Errorf("Prove() failed with %s", err)
+	} else if len(proof) != 0 {
+		t.Errorf("Prove(): got %v, want empty proof", proof)
+	}
+
+	for i := 0; i < 64; i++ {
+		err := mpt.Put([]byte{byte(i * 4), 0x12, byte(i)}, bytes.Repeat([]byte{byte(i)}, i))
+		if err != nil {
+			t.Fatalf("Put(%d) failed with %s", i, err)
+		}
+	}
+
+	cases := []struct {
+		key []byte
+		n   int
+	}{
+		{key: []byte{0x00, 0x12, 0x00}, n: 2},
+		{key: []byte{0x10, 0x12, 0x04}, n: 2},
+		{key: []byte{0xFC, 0x12, 0x3F}, n: 3},
+		{key: []byte{0xF0, 0x12, 0x3D}, n: 3},
+		{key: []byte{0x11}, n: 2},
+		{key: []byte{}, n: 1},
+	}
+
+	for _, c := range cases {
+		proof, err := mpt.Prove(c.key)
+		if err != nil {
+			t.Errorf("Prove(%v) failed with %s", c.key, err)
+			continue
+		}
+		if len(proof) != c.n {
+			t.Errorf("Prove(%v): got %d nodes, want %d", c.key, len(proof), c.n)
+			continue
+		}
+
+		if !bytes.Equal(keccak256(proof[0]), mpt.Hash()) {
+			t.Errorf("Prove(%v): first node is not the root", c.key)
+		}
+		for pi := 1; pi < len(proof); pi++ {
+			if !bytes.Contains(proof[pi-1], keccak256(proof[pi])) {
+				t.Errorf("Prove(%v): node %d is not referenced by node %d", c.key, pi, pi-1)
+			}
+		}
+	}
+}
+
+func TestVerifyProof(t *testing.T) {
+	mpt := New()
+	for _, kv := range []struct{ k, v string }{
+		{"doe", "reindeer"},
+		{"dog", "puppy"},
+		{"dogglesworth", "cat"},
+	} {
+		err := mpt.Put([]byte(kv.k), []byte(kv.v))
+		if err != nil {
+			t.Fatalf("Put(%s) failed with %s", kv.k, err)
+		}
+	}
+
+	root := mpt.Hash()
+	want := []byte{0x8a, 0xad, 0x78, 0x9d, 0xff, 0x2f, 0x53, 0x8b, 0xca, 0x5d, 0x8e, 0xa5, 0x6e,
+		0x8a, 0xbe, 0x10, 0xf4, 0xc7, 0xba, 0x3a, 0x5d, 0xea, 0x95, 0xfe, 0xa4, 0xcd, 0x6e, 0x7c,
+		0x3a, 0x11, 0x68, 0xd3}
+	if !bytes.Equal(root, want) {
+		t.Fatalf("Hash(): got %x, want %x", root, want)
+	}
+
+	for i := 0; i < 64; i++ {
+		err := mpt.Put([]byte{byte(i * 4), 0x12, byte(i)}, bytes.Repeat([]byte{byte(i)}, i+1))
+		if err != nil {
+			t.Fatalf("Put(%d) failed with %s", i, err)
+		}
+	}
+	root = mpt.Hash()
+
+	cases := []struct {
+		key []byte
+		val []byte
+	}{
+		{key: []byte("doe"), val: []byte("reindeer")},
+		{key: []byte("dog"), val: []byte("puppy")},
+		{key: []byte("dogglesworth"), val: []byte("cat")},
+		{key: []byte("do")},
+		{key: []byte("dogs")},
+		{key: []byte{0x00, 0x12, 0x00}, val: []byte{0x00}},
+		{key: []byte{0xFC, 0x12, 0x3F}, val: bytes.Repeat([]byte{0x3F}, 64)},
+		{key: []byte{0xF0, 0x12, 0x3D}},
+		{key: []byte{0x11}},
+		{key: []byte{}},
+	}
+
+	for _, c := range cases {
+		proof, err := mpt.Prove(c.key)
+		if err != nil {
+			t.Errorf("Prove(%v) failed with %s", c.key, err)
+			continue
+		}
+
+		val, err := VerifyProof(root, c.key, proof)
+		if c.val == nil {
+			if err != ErrNotFound {
+				t.Errorf("VerifyProof(%v): got %v, want not found", c.key, err)
+			}
+		} else if err != nil {
+			t.Errorf("VerifyProof(%v) failed with %s", c.key, err)
+		} else if !bytes.Equal(val, c.val) {
+			t.Errorf("VerifyProof(%v): got %v, want %v", c.key, val, c.val)
+		}
+
+		_, err = VerifyProof(keccak256([]byte("wrong")), c.key, proof)
+		if !errors.Is(err, ErrBadProof) {
+			t.Errorf("VerifyProof(%v) with wrong root: got %v, want bad proof", c.key, err)
+		}
+
+		last := append([]byte{}, proof[len(proof)-1]...)
+		last[len(last)-1] ^= 0x01
+		proof[len(proof)-1] = last
+		val, err = VerifyProof(root, c.key, proof)
+		if !errors.Is(err, ErrBadProof) {
+			t.Errorf("VerifyProof(%v) with changed proof: got %v, %v, want bad proof", c.key,
+				val, err)
+		}
+	}
+
+	_, err := VerifyProof(emptyHash, []byte("dog"), nil)
+	if err != ErrNotFound {
+		t.Errorf("VerifyProof(empty trie): got %v, want not found", err)
+	}
+}
+
+func TestMultiProof(t *testing.T) {
+	mpt := New()
+	for i := 0; i < 500; i++ {
+		err := mpt.Put(testKey(i), testValue(i))
+		if err != nil {
+			t.Fatalf("Put(%d) failed with %s", i, err)
+		}
+	}
+	root := mpt.Hash()
+
+	var keys [][]byte
+	var want [][]byte
+	var total int
+	for i := 0; i < 200; i += 3 {
+		keys = append(keys, testKey(i))
+		want = append(want, testValue(i))
+		if i%2 == 0 {
+			keys = append(keys, []byte{byte(i), byte(i * 7), 0x13})
+			want = append(want, nil)
+		}
+	}
+	keys = append(keys, testKey(0))
+	want = append(want, testValue(0))
+
+	for _, key := range keys {
+		proof, err := mpt.Prove(key)
+		if err != nil {
+			t.Fatalf("Prove(%v) failed with %s", key, err)
+		}
+		total += len(proof)
+	}
+
+	proof, err := mpt.ProveMulti(keys)
+	if err != nil {
+		t.Fatalf("ProveMulti() failed with %s", err)
+	}
+	if !bytes.Equal(keccak256(proof[0]), root) {
+		t.Error("ProveMulti(): first node is not the root")
+	}
+	if len(proof) >= total/2 {
+		t.Errorf("ProveMulti(): got %d nodes, want fewer than %d", len(proof), total/2)
+	}
+
+	reversed := make([][]byte, len(keys))
+	for ki, key := range keys {
+		reversed[len(keys)-ki-1] = key
+	}
+	other, err := mpt.ProveMulti(reversed)
+	if err != nil {
+		t.Fatalf("ProveMulti() failed with %s", err)
+	}
+	if len(other) != len(proof) {
+		t.Errorf("ProveMulti(reversed): got %d nodes, want %d", len(other), len(proof))
+	} else {
+		for pi := range proof {
+			if !bytes.Equal(proof[pi], other[pi]) {
+				t.Errorf("ProveMulti(reversed): node %d is different", pi)
+			}
+		}
+	}
+
+	values, err := VerifyMultiProof(root, keys, proof)
+	if err != nil {
+		t.Fatalf("VerifyMultiProof() failed with %s", err)
+	}
+	for ki := range keys {
+		if !bytes.Equal(values[ki], want[ki]) || (values[ki] == nil) != (want[ki] == nil) {
+			t.Errorf("VerifyMultiProof(%v): got %v, want %v", keys[ki], values[ki], want[ki])
+		}
+	}
+
+	_, err = VerifyMultiProof(root, keys, proof[:len(proof)-1])
+	if !errors.Is(err, ErrBadProof) {
+		t.Errorf("VerifyMultiProof(missing node): got %v, want bad proof", err)
+	}
+
+	values, err = VerifyMultiProof(emptyHash, keys, nil)
+	if err != nil {
+		t.Errorf("VerifyMultiProof(empty trie) failed with %s", err)
+	} else if len(values) != len(keys) {
+		t.Errorf("VerifyMultiProof(empty trie): got %d values, want %d", len(values), len(keys))
+	}
+}
+
+func TestOpenPartial(t *testing.T) {
+	mpt := New()
+	for i := 0; i < 500; i++ {
+		err := mpt.Put(testKey(i), testValue(i))
+		if err != nil {
+			t.Fatalf("Put(%d) failed with %s", i, err)
+		}
+	}
+	root := mpt.Hash()
+
+	var keys [][]byte
+	for i := 0; i < 500; i += 10 {
+		keys = append(keys, testKey(i))
+	}
+	absent := []byte{0x00, 0x00, 0x13}
+	keys = append(keys, absent)
+	proof, err := mpt.ProveMulti(keys)
+	if err != nil {
+		t.Fatalf("ProveMulti() failed with %s", err)
+	}
+
+	pt, err := OpenPartial(root, proof)
+	if err != nil {
+		t.Fatalf("OpenPartial() failed with %s", err)
+	}
+	if !bytes.Equal(pt.Hash(), root) {
+		t.Errorf("Hash(): got %x, want %x", pt.Hash(), root)
+	}
+	for i := 0; i < 500; i += 10 {
+		val, err := pt.Get(testKey(i))
+		if err != nil {
+			t.Errorf("Get(%d) failed with %s", i, err)
+		} else if !bytes.Equal(val, testValue(i)) {
+			t.Errorf("Get(%d): got %v, want %v", i, val, testValue(i))
+		}
+	}
+	_, err = pt.Get(absent)
+	if err != ErrNotFound {
+		t.Errorf("Get(%v): got %v, want not found", absent, err)
+	}
+
+	for i := 0; i < 500; i += 20 {
+		val := []byte("changed")
+		err := pt.Put(testKey(i), val)
+		if err != nil {
+			t.Fatalf("Put(%d) failed with %s", i, err)
+		}
+		err = mpt.Put(testKey(i), val)
+		if err != nil {
+			t.Fatalf("Put(%d) failed with %s", i, err)
+		}
+	}
+	for _, m := range []*MPTrie{pt, mpt} {
+		err := m.Put(absent, []byte("added"))
+		if err != nil {
+			t.Fatalf("Put(%v) failed with %s", absent, err)
+		}
+	}
+	if !bytes.Equal(pt.Hash(), mpt.Hash()) {
+		t.Errorf("Hash(): got %x, want %x", pt.Hash(), mpt.Hash())
+	}
+
+	// Keys which are not covered by the proof need nodes which are missing, unless they are
+	// included in a node which is in the proof.
+	h := pt.Hash()
+	var missing int
+	for i := 1; i < 500; i += 2 {
+		key := testKey(i)
+		_, err := pt.Get(key)
+		if err == nil {
+			continue
+		} else if _, ok := err.(*MissingNodeError); !ok {
+			t.Fatalf("Get(%v): got %v, want missing node", key, err)
+		}
+		missing += 1
+
+		err = pt.Put(key, []byte("changed"))
+		if _, ok := err.(*MissingNodeError); !ok {
+			t.Errorf("Put(%v): got %v, want missing node", key, err)
+		}
+		err = pt.Delete(key)
+		if _, ok := err.(*MissingNodeError); !ok {
+			t.Errorf("Delete(%v): got %v, want missing node", key, err)
+		}
+		if !bytes.Equal(pt.Hash(), h) {
+			t.Errorf("Put(%v) or Delete(%v) changed the trie", key, key)
+		}
+	}
+	if missing < 200 {
+		t.Errorf("Get(): got %d missing nodes, want at least 200", missing)
+	}
+}
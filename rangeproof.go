@@ -0,0 +1,221 @@
+package mptrie
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// ProveRange returns the keys and values, in order, which are at least start and at most end,
+// and a proof that they are all of the keys in that range. A nil end means there is no upper
+// bound, and at most limit keys are returned if limit is greater than zero. If there are no
+// keys in the range, the first key after end, if any, is returned, so that no keys are only
+// returned when there are none from start on. The proof is made up of the nodes on the paths
+// to start and to the last key returned.
+func (mpt *MPTrie) ProveRange(start, end []byte, limit int) ([][]byte, [][]byte, [][]byte, error) {
+	var keys, values [][]byte
+	it := mpt.Iterator(start)
+	for (limit <= 0 || len(keys) < limit) && it.Next() {
+		if end != nil && len(keys) > 0 && bytes.Compare(it.Key(), end) > 0 {
+			break
+		}
+		keys = append(keys, it.Key())
+		values = append(values, it.Value())
+	}
+	if it.Err() != nil {
+		return nil, nil, nil, it.Err()
+	}
+
+	proof, err := mpt.Prove(start)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	if len(keys) > 0 {
+		last, err := mpt.Prove(keys[len(keys)-1])
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		seen := map[string]struct{}{}
+		for _, buf := range proof {
+			seen[string(buf)] = struct{}{}
+		}
+		for _, buf := range last {
+			if _, ok := seen[string(buf)]; !ok {
+				proof = append(proof, buf)
+			}
+		}
+	}
+
+	return keys, values, proof, nil
+}
+
+// VerifyRangeProof checks that keys and values, as returned by ProveRange, are all of the keys
+// from firstKey through the last key in the trie with the given root hash. It returns true if
+// the trie has more keys after the last key. If proof is empty, keys and values must be the
+// whole trie. If keys is empty, proof must show that there are no keys from firstKey on.
+func VerifyRangeProof(root, firstKey []byte, keys, values, proof [][]byte) (bool, error) {
+	if len(keys) != len(values) {
+		return false, fmt.Errorf("%w: %d keys but %d values", ErrBadProof, len(keys),
+			len(values))
+	}
+	for ki, key := range keys {
+		if ki == 0 && bytes.Compare(key, firstKey) < 0 {
+			return false, fmt.Errorf("%w: key %x is before first key %x", ErrBadProof, key,
+				firstKey)
+		} else if ki > 0 && bytes.Compare(keys[ki-1], key) >= 0 {
+			return false, fmt.Errorf("%w: keys are not in order: %x", ErrBadProof, key)
+		}
+	}
+
+	if len(proof) == 0 {
+		mpt := New()
+		for ki := range keys {
+			err := mpt.Put(keys[ki], values[ki])
+			if err != nil {
+				return false, err
+			}
+		}
+		if !bytes.Equal(mpt.Hash(), root) {
+			return false, fmt.Errorf("%w: keys do not match root %x", ErrBadProof, root)
+		}
+		return false, nil
+	}
+
+	store := NewMemoryStore()
+	for _, buf := range proof {
+		err := store.Put(keccak256(buf), buf)
+		if err != nil {
+			return false, err
+		}
+	}
+	mpt, err := Open(root, store)
+	if err != nil {
+		return false, err
+	}
+
+	rc := &rangeCut{
+		mpt:   mpt,
+		left:  keyToNibbleKey(firstKey),
+		right: keyToNibbleKey(firstKey),
+	}
+	if len(keys) > 0 {
+		rc.right = keyToNibbleKey(keys[len(keys)-1])
+	}
+
+	// Remove every key in the range from the trie built from the proof, leaving only the keys
+	// outside of the range, then put back keys: the result must be the original trie.
+	mpt.root, err = rc.cutNode(mpt.root, nil)
+	if err != nil {
+		return false, err
+	}
+	if len(keys) == 0 && rc.more {
+		return false, fmt.Errorf("%w: trie has keys from first key %x", ErrBadProof, firstKey)
+	}
+
+	mpt.hash = nil
+	for ki := range keys {
+		err := mpt.Put(keys[ki], values[ki])
+		if _, ok := err.(*MissingNodeError); ok {
+			return false, fmt.Errorf("%w: %s", ErrBadProof, err)
+		} else if err != nil {
+			return false, err
+		}
+	}
+	if !bytes.Equal(mpt.Hash(), root) {
+		return false, fmt.Errorf("%w: keys do not match root %x", ErrBadProof, root)
+	}
+	return rc.more, nil
+}
+
+type rangeCut struct {
+	mpt         *MPTrie
+	left, right nibbleKey
+	more        bool // A key after right was found.
+}
+
+// comparePrefix returns -1 if every key starting with prefix is before nk, 1 if every such key
+// is after nk, and 0 otherwise.
+func comparePrefix(prefix, nk nibbleKey) int {
+	l := len(prefix)
+	if l > len(nk) {
+		l = len(nk)
+	}
+	if c := bytes.Compare(prefix[:l], nk[:l]); c != 0 {
+		return c
+	} else if len(prefix) > len(nk) {
+		return 1
+	}
+	return 0
+}
+
+// cutNode removes the keys from left through right from n, found at path, and returns what
+// is left of n; the result is not necessarily a valid trie until the keys are put back.
+func (rc *rangeCut) cutNode(n node, path nibbleKey) (node, error) {
+	if n == nil {
+		return nil, nil
+	}
+
+	cl := comparePrefix(path, rc.left)
+	cr := comparePrefix(path, rc.right)
+	if cr > 0 {
+		rc.more = true
+		return n, nil
+	} else if cl < 0 {
+		return n, nil
+	} else if cl > 0 && cr < 0 {
+		return nil, nil
+	}
+
+	if hn, ok := n.(hashNode); ok {
+		var err error
+		n, err = rc.mpt.resolve(hn, path)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrBadProof, err)
+		}
+	}
+
+	if branch, ok := n.(*branchNode); ok {
+		branch = rc.mpt.mutableBranchNode(branch)
+		if branch.value != nil && bytes.Compare(path, rc.left) >= 0 &&
+			bytes.Compare(path, rc.right) <= 0 {
+
+			branch.value = nil
+		}
+
+		for ci, child := range branch.children {
+			var err error
+			branch.children[ci], err = rc.cutNode(child, joinNibbleKeys(path,
+				nibbleKey{byte(ci)}))
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if branch.value == nil && branch.noChildren() {
+			return nil, nil
+		}
+		return branch, nil
+	} else if extension, ok := n.(*extensionNode); ok {
+		child, err := rc.cutNode(extension.child, joinNibbleKeys(path, extension.subKey))
+		if err != nil {
+			return nil, err
+		} else if child == nil {
+			return nil, nil
+		}
+
+		extension = rc.mpt.mutableExtensionNode(extension)
+		extension.child = child
+		return extension, nil
+	} else if leaf, ok := n.(*leafNode); ok {
+		nk := joinNibbleKeys(path, leaf.suffixKey)
+		if bytes.Compare(nk, rc.right) > 0 {
+			rc.more = true
+			return leaf, nil
+		} else if bytes.Compare(nk, rc.left) < 0 {
+			return leaf, nil
+		}
+		return nil, nil
+	}
+
+	panic(fmt.Sprintf("unexpected mptrie node: %#v", n))
+}
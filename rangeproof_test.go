@@ -0,0 +1,120 @@
+package mptrie
+
+import (
+	"bytes"
+	"errors"
+	"math/rand"
+	"testing"
+)
+
+func TestRangeProof(t *testing.T) {
+	mpt := New()
+	keys, values, proof, err := mpt.ProveRange(nil, nil, 0)
+	if err != nil {
+		t.Fatalf("ProveRange() failed with %s", err)
+	}
+	more, err := VerifyRangeProof(mpt.Hash(), nil, keys, values, proof)
+	if err != nil {
+		t.Errorf("VerifyRangeProof() failed with %s", err)
+	} else if more {
+		t.Error("VerifyRangeProof(): got more, want false")
+	}
+
+	var all [][]byte
+	for i := 0; i < 1000; i++ {
+		key := testKey(i)[:1+i%4]
+		err := mpt.Put(key, testValue(i))
+		if err != nil {
+			t.Fatalf("Put(%d) failed with %s", i, err)
+		}
+	}
+	it := mpt.Iterator(nil)
+	for it.Next() {
+		all = append(all, it.Key())
+	}
+	root := mpt.Hash()
+
+	r := rand.New(rand.NewSource(1))
+	for n := 0; n < 200; n++ {
+		start := testKey(r.Intn(1000))[:r.Intn(5)]
+		var end []byte
+		if r.Intn(4) > 0 {
+			end = testKey(r.Intn(1000))[:1+r.Intn(4)]
+		}
+		limit := r.Intn(100)
+
+		keys, values, proof, err := mpt.ProveRange(start, end, limit)
+		if err != nil {
+			t.Fatalf("ProveRange(%x, %x, %d) failed with %s", start, end, limit, err)
+		}
+		more, err := VerifyRangeProof(root, start, keys, values, proof)
+		if err != nil {
+			t.Fatalf("VerifyRangeProof(%x, %x, %d) failed with %s", start, end, limit, err)
+		}
+
+		last := start
+		if len(keys) > 0 {
+			last = keys[len(keys)-1]
+		}
+		if want := bytes.Compare(all[len(all)-1], last) > 0; more != want {
+			t.Errorf("VerifyRangeProof(%x, %x, %d): got more %v, want %v", start, end, limit,
+				more, want)
+		}
+
+		if len(keys) < 3 {
+			continue
+		}
+
+		badKeys := append(append([][]byte{}, keys[:1]...), keys[2:]...)
+		badValues := append(append([][]byte{}, values[:1]...), values[2:]...)
+		_, err = VerifyRangeProof(root, start, badKeys, badValues, proof)
+		if !errors.Is(err, ErrBadProof) {
+			t.Errorf("VerifyRangeProof(missing key): got %v, want ErrBadProof", err)
+		}
+
+		_, err = VerifyRangeProof(root, start, keys[1:], values[1:], proof)
+		if !errors.Is(err, ErrBadProof) {
+			t.Errorf("VerifyRangeProof(missing first key): got %v, want ErrBadProof", err)
+		}
+
+		badValues = append([][]byte{}, values...)
+		badValues[1] = []byte("changed")
+		_, err = VerifyRangeProof(root, start, keys, badValues, proof)
+		if !errors.Is(err, ErrBadProof) {
+			t.Errorf("VerifyRangeProof(changed value): got %v, want ErrBadProof", err)
+		}
+
+		badKeys = append([][]byte{}, keys...)
+		badKeys[1], badKeys[2] = badKeys[2], badKeys[1]
+		_, err = VerifyRangeProof(root, start, badKeys, values, proof)
+		if !errors.Is(err, ErrBadProof) {
+			t.Errorf("VerifyRangeProof(unordered keys): got %v, want ErrBadProof", err)
+		}
+	}
+
+	keys, values, proof, err = mpt.ProveRange(nil, nil, 0)
+	if err != nil {
+		t.Fatalf("ProveRange() failed with %s", err)
+	} else if len(keys) != len(all) {
+		t.Fatalf("ProveRange(): got %d keys, want %d", len(keys), len(all))
+	}
+	more, err = VerifyRangeProof(root, nil, keys, values, nil)
+	if err != nil {
+		t.Errorf("VerifyRangeProof() failed with %s", err)
+	} else if more {
+		t.Error("VerifyRangeProof(): got more, want false")
+	}
+	_, err = VerifyRangeProof(root, nil, keys[1:], values[1:], nil)
+	if !errors.Is(err, ErrBadProof) {
+		t.Errorf("VerifyRangeProof(missing key): got %v, want ErrBadProof", err)
+	}
+
+	_, _, proof, err = mpt.ProveRange(all[len(all)/2], nil, 0)
+	if err != nil {
+		t.Fatalf("ProveRange() failed with %s", err)
+	}
+	_, err = VerifyRangeProof(root, all[len(all)/2], nil, nil, proof)
+	if !errors.Is(err, ErrBadProof) {
+		t.Errorf("VerifyRangeProof(no keys): got %v, want ErrBadProof", err)
+	}
+}
@@ -0,0 +1,60 @@
+package mptrie
+
+import "fmt"
+
+// Recorder collects the encodings of the nodes of a trie which are visited by Get, Put and
+// Delete. The nodes are a witness: a trie opened from the original root hash over a store
+// holding just these nodes can repeat the same operations and reach the same root hash.
+type Recorder struct {
+	nodes [][]byte
+	seen  map[string]struct{}
+}
+
+func NewRecorder() *Recorder {
+	return &Recorder{
+		seen: map[string]struct{}{},
+	}
+}
+
+// Witness returns the encodings of the recorded nodes in the order they were first visited.
+func (r *Recorder) Witness() [][]byte {
+	return r.nodes
+}
+
+// SetRecorder starts recording the nodes of the trie as it is now to r; r may be nil to stop
+// recording.
+func (mpt *MPTrie) SetRecorder(r *Recorder) {
+	// Nodes are never changed by a later generation of the trie, so the nodes to record are
+	// exactly those belonging to an older generation.
+	mpt.generation += 1
+	mpt.recorder = r
+}
+
+func nodeGeneration(n node) int64 {
+	if branch, ok := n.(*branchNode); ok {
+		return branch.generation
+	} else if extension, ok := n.(*extensionNode); ok {
+		return extension.generation
+	} else if leaf, ok := n.(*leafNode); ok {
+		return leaf.generation
+	}
+
+	panic(fmt.Sprintf("unexpected mptrie node: %#v", n))
+}
+
+// record adds n, found at path, to the recorder, if any. Nodes included in the encoding of
+// their parent are not recorded separately.
+func (mpt *MPTrie) record(n node, path nibbleKey) {
+	if mpt.recorder == nil || nodeGeneration(n) == mpt.generation {
+		return
+	}
+
+	buf := n.encode()
+	if len(path) > 0 && len(buf) < 32 {
+		return
+	}
+	if _, ok := mpt.recorder.seen[string(buf)]; !ok {
+		mpt.recorder.seen[string(buf)] = struct{}{}
+		mpt.recorder.nodes = append(mpt.recorder.nodes, buf)
+	}
+}
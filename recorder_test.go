@@ -0,0 +1,127 @@
+package mptrie
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+type recordedOp struct {
+	op  byte
+	key []byte
+	val []byte
+}
+
+func replayOps(t *testing.T, mpt *MPTrie, ops []recordedOp) [][]byte {
+	t.Helper()
+
+	var values [][]byte
+	for _, op := range ops {
+		var err error
+		switch op.op {
+		case 'g':
+			var val []byte
+			val, err = mpt.Get(op.key)
+			values = append(values, val)
+		case 'p':
+			err = mpt.Put(op.key, op.val)
+		case 'd':
+			err = mpt.Delete(op.key)
+		}
+		if err != nil && err != ErrNotFound {
+			t.Fatalf("%c(%v) failed with %s", op.op, op.key, err)
+		}
+	}
+	return values
+}
+
+func TestRecorder(t *testing.T) {
+	mpt := New()
+	for i := 0; i < 1000; i++ {
+		err := mpt.Put(testKey(i), testValue(i))
+		if err != nil {
+			t.Fatalf("Put(%d) failed with %s", i, err)
+		}
+	}
+
+	for i := 0; i < 16; i++ {
+		err := mpt.Put([]byte{0xAB, 0x01, byte(i)}, testValue(40))
+		if err != nil {
+			t.Fatalf("Put(%d) failed with %s", i, err)
+		}
+	}
+	err := mpt.Put([]byte{0xAB, 0x02}, testValue(0))
+	if err != nil {
+		t.Fatalf("Put() failed with %s", err)
+	}
+
+	store := NewMemoryStore()
+	root, err := mpt.Commit(store)
+	if err != nil {
+		t.Fatalf("Commit() failed with %s", err)
+	}
+
+	r := rand.New(rand.NewSource(1))
+	var ops []recordedOp
+	for i := 0; i < 100; i++ {
+		op := recordedOp{op: "gpd"[r.Intn(3)], key: testKey(r.Intn(1200))}
+		if op.op == 'p' {
+			op.val = testValue(r.Intn(100))
+		}
+		ops = append(ops, op)
+	}
+	// The branch for 0xAB0 collapses into its remaining child, which must be loaded.
+	ops = append(ops, recordedOp{op: 'd', key: []byte{0xAB, 0x02}})
+
+	for _, c := range []struct {
+		name string
+		mpt  *MPTrie
+	}{
+		{name: "memory", mpt: mpt},
+		{name: "store", mpt: func() *MPTrie {
+			mpt, err := Open(root, store)
+			if err != nil {
+				t.Fatalf("Open() failed with %s", err)
+			}
+			return mpt
+		}()},
+	} {
+		rec := NewRecorder()
+		c.mpt.SetRecorder(rec)
+		want := replayOps(t, c.mpt, ops)
+		newRoot := c.mpt.Hash()
+		if bytes.Equal(newRoot, root) {
+			t.Fatalf("%s: root did not change", c.name)
+		}
+
+		witness := NewMemoryStore()
+		for _, buf := range rec.Witness() {
+			hash := keccak256(buf)
+			if _, err := store.Get(hash); err != nil {
+				t.Errorf("%s: witness node %x is not in the original trie", c.name, hash)
+			}
+			err := witness.Put(hash, buf)
+			if err != nil {
+				t.Fatalf("Put() failed with %s", err)
+			}
+		}
+		if witness.Len() >= store.Len()/2 {
+			t.Errorf("%s: got %d witness nodes, want fewer than %d", c.name, witness.Len(),
+				store.Len()/2)
+		}
+
+		verifier, err := Open(root, witness)
+		if err != nil {
+			t.Fatalf("Open() failed with %s", err)
+		}
+		got := replayOps(t, verifier, ops)
+		if !bytes.Equal(verifier.Hash(), newRoot) {
+			t.Errorf("%s: Hash(): got %x, want %x", c.name, verifier.Hash(), newRoot)
+		}
+		for i := range want {
+			if !bytes.Equal(got[i], want[i]) {
+				t.Errorf("%s: Get(%d): got %v, want %v", c.name, i, got[i], want[i])
+			}
+		}
+	}
+}
@@ -1,5 +1,7 @@
 package mptrie
 
+import "fmt"
+
 func encodeBytes(buf []byte, bs []byte) []byte {
 	if len(bs) == 1 && bs[0] < 128 {
 		return append(buf, bs[0])
@@ -64,6 +66,32 @@ func encodeUint(buf []byte, u uint64) (int, []byte) {
 	return 8, append(buf, byte(u))
 }
 
+// encodeScalar returns the RLP encoding of u as an integer: a string holding its big endian
+// bytes without leading zeros.
+func encodeScalar(u uint64) []byte {
+	if u == 0 {
+		return encodeBytes(nil, nil)
+	}
+
+	_, buf := encodeUint(nil, u)
+	return encodeBytes(nil, buf)
+}
+
+// decodeScalar returns the integer held in str, which must not have leading zeros.
+func decodeScalar(str []byte) (uint64, error) {
+	if len(str) > 8 {
+		return 0, fmt.Errorf("mptrie: integer too large: %x", str)
+	} else if len(str) > 0 && str[0] == 0 {
+		return 0, fmt.Errorf("mptrie: integer has leading zeros: %x", str)
+	}
+
+	var u uint64
+	for _, b := range str {
+		u = u<<8 | uint64(b)
+	}
+	return u, nil
+}
+
 func encodeTuple(buf []byte, ts ...[]byte) []byte {
 	var tl uint64
 	for _, bs := range ts {
@@ -85,3 +113,131 @@ func encodeTuple(buf []byte, ts ...[]byte) []byte {
 	}
 	return buf
 }
+
+// RLPError is returned when decoding invalid or non-canonical RLP; Offset is the position in
+// the input where the problem was found.
+type RLPError struct {
+	Offset int
+	Msg    string
+}
+
+func (err *RLPError) Error() string {
+	return fmt.Sprintf("rlp: offset %d: %s", err.Offset, err.Msg)
+}
+
+type rlpItem struct {
+	list  bool
+	raw   []byte    // The complete encoding of the item.
+	str   []byte    // The contents of a string.
+	items []rlpItem // The items of a list.
+}
+
+// decodeRLP decodes buf, which must contain exactly one item.
+func decodeRLP(buf []byte) (rlpItem, error) {
+	item, rest, err := decodeItem(buf, 0)
+	if err != nil {
+		return rlpItem{}, err
+	} else if len(rest) > 0 {
+		return rlpItem{}, &RLPError{Offset: len(buf) - len(rest), Msg: "trailing data"}
+	}
+	return item, nil
+}
+
+// decodeItem decodes the first item in buf, and returns it and the rest of buf; off is the
+// offset of buf in the input.
+func decodeItem(buf []byte, off int) (rlpItem, []byte, error) {
+	list, hl, cl, err := decodeHeader(buf, off)
+	if err != nil {
+		return rlpItem{}, nil, err
+	}
+
+	item := rlpItem{
+		list: list,
+		raw:  buf[:hl+cl],
+	}
+	content := buf[hl : hl+cl]
+	if !list {
+		item.str = content
+		return item, buf[hl+cl:], nil
+	}
+
+	item.items = []rlpItem{}
+	off += hl
+	for len(content) > 0 {
+		var ci rlpItem
+		ci, content, err = decodeItem(content, off)
+		if err != nil {
+			return rlpItem{}, nil, err
+		}
+		item.items = append(item.items, ci)
+		off += len(ci.raw)
+	}
+	return item, buf[hl+cl:], nil
+}
+
+// decodeHeader returns whether the first item in buf is a list, the length of its header, and
+// the length of its contents.
+func decodeHeader(buf []byte, off int) (bool, int, int, error) {
+	if len(buf) == 0 {
+		return false, 0, 0, &RLPError{Offset: off, Msg: "unexpected end of input"}
+	}
+
+	var list bool
+	var hl, cl int
+	b := buf[0]
+	if b < 0x80 {
+		return false, 0, 1, nil
+	} else if b < 0xB8 {
+		hl, cl = 1, int(b-0x80)
+		if cl == 1 && len(buf) > 1 && buf[1] < 0x80 {
+			return false, 0, 0,
+				&RLPError{Offset: off, Msg: "single byte below 0x80 encoded as a string"}
+		}
+	} else if b < 0xC0 {
+		var err error
+		hl, cl, err = decodeLength(buf, int(b-0xB7), off)
+		if err != nil {
+			return false, 0, 0, err
+		}
+	} else if b < 0xF8 {
+		list = true
+		hl, cl = 1, int(b-0xC0)
+	} else {
+		list = true
+		var err error
+		hl, cl, err = decodeLength(buf, int(b-0xF7), off)
+		if err != nil {
+			return false, 0, 0, err
+		}
+	}
+
+	if cl > len(buf)-hl {
+		return false, 0, 0, &RLPError{
+			Offset: off,
+			Msg:    fmt.Sprintf("length %d is longer than the remaining %d bytes", cl, len(buf)-hl),
+		}
+	}
+	return list, hl, cl, nil
+}
+
+func decodeLength(buf []byte, ll int, off int) (int, int, error) {
+	if ll >= len(buf) {
+		return 0, 0, &RLPError{Offset: off, Msg: "unexpected end of input in length"}
+	} else if buf[1] == 0 {
+		return 0, 0, &RLPError{Offset: off + 1, Msg: "length has leading zero bytes"}
+	}
+
+	var l uint64
+	for _, b := range buf[1 : 1+ll] {
+		l = (l << 8) | uint64(b)
+	}
+	if l < 56 {
+		return 0, 0, &RLPError{Offset: off, Msg: fmt.Sprintf("length %d must use short form", l)}
+	} else if l > uint64(len(buf)) {
+		return 0, 0, &RLPError{
+			Offset: off,
+			Msg:    fmt.Sprintf("length %d is longer than the remaining %d bytes", l, len(buf)-1-ll),
+		}
+	}
+	return 1 + ll, int(l), nil
+}
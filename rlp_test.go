@@ -83,6 +83,29 @@ func TestEncodeUint(t *testing.T) {
 	}
 }
 
+func TestScalar(t *testing.T) {
+	for _, u := range []uint64{0, 1, 0x7F, 0x80, 0xABCD, 0x0102030405060708, 0xFFFFFFFFFFFFFFFF} {
+		item, err := decodeRLP(encodeScalar(u))
+		if err != nil {
+			t.Errorf("decodeRLP(encodeScalar(%x)) failed with %s", u, err)
+			continue
+		}
+		du, err := decodeScalar(item.str)
+		if err != nil {
+			t.Errorf("decodeScalar(%x) failed with %s", item.str, err)
+		} else if du != u {
+			t.Errorf("decodeScalar(%x): got %x, want %x", item.str, du, u)
+		}
+	}
+
+	for _, str := range [][]byte{{0x00}, {0x00, 0x01}, make([]byte, 9)} {
+		_, err := decodeScalar(str)
+		if err == nil {
+			t.Errorf("decodeScalar(%x) did not fail", str)
+		}
+	}
+}
+
 func TestEncodeTuple(t *testing.T) {
 	cases := []struct {
 		ts  [][]byte
@@ -169,3 +192,71 @@ func TestEncodeTuple(t *testing.T) {
 		}
 	}
 }
+
+func TestDecodeRLP(t *testing.T) {
+	strs := [][]byte{{}, {0x00}, {0x7F}, {0x80}, {0x00, 0x11}, makeByteSlice(55),
+		makeByteSlice(56), makeByteSlice(255), makeByteSlice(0xFEED)}
+	for _, bs := range strs {
+		item, err := decodeRLP(encodeBytes(nil, bs))
+		if err != nil {
+			t.Errorf("decodeRLP(encodeBytes(%d bytes)) failed with %s", len(bs), err)
+		} else if item.list || !bytes.Equal(item.str, bs) {
+			t.Errorf("decodeRLP(encodeBytes(%d bytes)): got %v", len(bs), item)
+		}
+	}
+
+	for _, n := range []int{0, 1, 2, 3, 17, 100} {
+		var ets [][]byte
+		for i := 0; i < n; i++ {
+			ets = append(ets, encodeBytes(nil, makeByteSlice(i)))
+		}
+		ets = append(ets, encodeTuple(nil, encodeBytes(nil, nil)))
+
+		buf := encodeTuple(nil, ets...)
+		item, err := decodeRLP(buf)
+		if err != nil {
+			t.Errorf("decodeRLP(%d items) failed with %s", n, err)
+			continue
+		}
+		if !item.list || len(item.items) != n+1 || !bytes.Equal(item.raw, buf) {
+			t.Errorf("decodeRLP(%d items): got %v", n, item)
+			continue
+		}
+		for i := 0; i < n; i++ {
+			if item.items[i].list || !bytes.Equal(item.items[i].str, makeByteSlice(i)) {
+				t.Errorf("decodeRLP(%d items): item %d: got %v", n, i, item.items[i])
+			}
+		}
+		last := item.items[n]
+		if !last.list || len(last.items) != 1 || len(last.items[0].str) != 0 {
+			t.Errorf("decodeRLP(%d items): last item: got %v", n, last)
+		}
+	}
+
+	cases := []struct {
+		buf []byte
+		off int
+	}{
+		{buf: []byte{}, off: 0},
+		{buf: []byte{0x81, 0x7F}, off: 0},
+		{buf: []byte{0x82, 0x01}, off: 0},
+		{buf: []byte{0xB8, 0x37}, off: 0},
+		{buf: []byte{0xB9, 0x00, 0x38}, off: 1},
+		{buf: []byte{0xB8}, off: 0},
+		{buf: []byte{0xF8, 0x02, 0x80, 0x80}, off: 0},
+		{buf: []byte{0xC2, 0x80}, off: 0},
+		{buf: []byte{0xC3, 0x80, 0x81, 0x01}, off: 2},
+		{buf: []byte{0xC3, 0x80, 0xC1, 0x81}, off: 3},
+		{buf: []byte{0x80, 0x80}, off: 1},
+		{buf: []byte{0xC1, 0x80, 0x80}, off: 2},
+	}
+
+	for _, c := range cases {
+		_, err := decodeRLP(c.buf)
+		if rerr, ok := err.(*RLPError); !ok {
+			t.Errorf("decodeRLP(%v): got %v, want RLPError", c.buf, err)
+		} else if rerr.Offset != c.off {
+			t.Errorf("decodeRLP(%v): got offset %d, want %d: %s", c.buf, rerr.Offset, c.off, err)
+		}
+	}
+}
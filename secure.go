@@ -0,0 +1,129 @@
+package mptrie
+
+// PreimageStore holds the keys of a secure trie by their keccak256 hash. Get must return
+// ErrNotFound if there is no key with hash in the store. The keys are not nodes, so a NodeStore
+// which decodes what it is given, such as a Database, can not be used; a MemoryStore can.
+type PreimageStore interface {
+	Get(hash []byte) ([]byte, error)
+	Put(hash, key []byte) error
+}
+
+// SecureTrie is a trie which uses the keccak256 hash of each key as the key in the underlying
+// trie, as the Ethereum state and storage tries do. If a preimage store is provided, the key
+// for each hash is stored in it so that iteration can return the original keys.
+type SecureTrie struct {
+	trie      *MPTrie
+	preimages PreimageStore
+}
+
+// NewSecureTrie returns a secure trie using mpt, which must only contain hashed keys;
+// preimages may be nil.
+func NewSecureTrie(mpt *MPTrie, preimages PreimageStore) *SecureTrie {
+	return &SecureTrie{
+		trie:      mpt,
+		preimages: preimages,
+	}
+}
+
+// Trie returns the underlying trie.
+func (st *SecureTrie) Trie() *MPTrie {
+	return st.trie
+}
+
+func (st *SecureTrie) Clone() *SecureTrie {
+	return &SecureTrie{
+		trie:      st.trie.Clone(),
+		preimages: st.preimages,
+	}
+}
+
+func (st *SecureTrie) Delete(key []byte) error {
+	return st.trie.Delete(keccak256(key))
+}
+
+func (st *SecureTrie) Get(key []byte) ([]byte, error) {
+	return st.trie.Get(keccak256(key))
+}
+
+func (st *SecureTrie) Hash() []byte {
+	return st.trie.Hash()
+}
+
+func (st *SecureTrie) Put(key, val []byte) error {
+	hash := keccak256(key)
+	if st.preimages != nil {
+		err := st.preimages.Put(hash, key)
+		if err != nil {
+			return err
+		}
+	}
+	return st.trie.Put(hash, val)
+}
+
+func (st *SecureTrie) Commit(store NodeStore) ([]byte, error) {
+	return st.trie.Commit(store)
+}
+
+// GetKey returns the key whose hash is hash; it returns ErrNotFound if there is no preimage
+// store or the key is not in it.
+func (st *SecureTrie) GetKey(hash []byte) ([]byte, error) {
+	if st.preimages == nil {
+		return nil, ErrNotFound
+	}
+	return st.preimages.Get(hash)
+}
+
+// SecureIterator returns the keys and values of a secure trie in the order of the hashes of
+// the keys.
+type SecureIterator struct {
+	st  *SecureTrie
+	it  *Iterator
+	key []byte
+	err error
+}
+
+// Iterator returns an iterator which starts at the first hashed key greater than or equal to
+// start.
+func (st *SecureTrie) Iterator(start []byte) *SecureIterator {
+	return &SecureIterator{
+		st: st,
+		it: st.trie.Iterator(start),
+	}
+}
+
+func (sit *SecureIterator) Next() bool {
+	sit.key = nil
+	if sit.err != nil || !sit.it.Next() {
+		return false
+	}
+
+	key, err := sit.st.GetKey(sit.it.Key())
+	if err == nil {
+		sit.key = key
+	} else if err != ErrNotFound {
+		sit.err = err
+		return false
+	}
+	return true
+}
+
+// Key returns the original key, or nil if its preimage is not known.
+func (sit *SecureIterator) Key() []byte {
+	return sit.key
+}
+
+// HashedKey returns the hash of the key.
+func (sit *SecureIterator) HashedKey() []byte {
+	return sit.it.Key()
+}
+
+func (sit *SecureIterator) Value() []byte {
+	return sit.it.Value()
+}
+
+func (sit *SecureIterator) Err() error {
+	if sit.err != nil {
+		return sit.err
+	}
+	return sit.it.Err()
+}
@@ -0,0 +1,103 @@
+package mptrie
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSecureTrie(t *testing.T) {
+	preimages := NewMemoryStore()
+	st := NewSecureTrie(New(), preimages)
+	mpt := New()
+
+	for i := 0; i < 100; i++ {
+		err := st.Put(testKey(i), testValue(i))
+		if err != nil {
+			t.Fatalf("Put(%d) failed with %s", i, err)
+		}
+		err = mpt.Put(keccak256(testKey(i)), testValue(i))
+		if err != nil {
+			t.Fatalf("Put(%d) failed with %s", i, err)
+		}
+	}
+	for i := 0; i < 100; i += 5 {
+		err := st.Delete(testKey(i))
+		if err != nil {
+			t.Fatalf("Delete(%d) failed with %s", i, err)
+		}
+		err = mpt.Delete(keccak256(testKey(i)))
+		if err != nil {
+			t.Fatalf("Delete(%d) failed with %s", i, err)
+		}
+	}
+
+	if !bytes.Equal(st.Hash(), mpt.Hash()) {
+		t.Errorf("Hash(): got %x, want %x", st.Hash(), mpt.Hash())
+	}
+
+	for i := 0; i < 100; i++ {
+		val, err := st.Get(testKey(i))
+		if i%5 == 0 {
+			if err != ErrNotFound {
+				t.Errorf("Get(%d): got %v, want not found", i, err)
+			}
+		} else if err != nil {
+			t.Errorf("Get(%d) failed with %s", i, err)
+		} else if !bytes.Equal(val, testValue(i)) {
+			t.Errorf("Get(%d): got %v, want %v", i, val, testValue(i))
+		}
+	}
+
+	sit := st.Iterator(nil)
+	it := mpt.Iterator(nil)
+	for it.Next() {
+		if !sit.Next() {
+			t.Fatalf("Iterator(): got end, want %x", it.Key())
+		}
+		if !bytes.Equal(sit.HashedKey(), it.Key()) || !bytes.Equal(sit.Value(), it.Value()) {
+			t.Errorf("Iterator(): got %x = %v, want %x = %v", sit.HashedKey(), sit.Value(),
+				it.Key(), it.Value())
+		}
+		if !bytes.Equal(keccak256(sit.Key()), it.Key()) {
+			t.Errorf("Iterator(): got key %v for hash %x", sit.Key(), it.Key())
+		}
+	}
+	if sit.Next() {
+		t.Errorf("Iterator(): got %x, want end", sit.HashedKey())
+	}
+	if sit.Err() != nil {
+		t.Errorf("Iterator() failed with %s", sit.Err())
+	}
+
+	// The nodes and the preimages are kept in different stores.
+	db := NewDatabase(NewMemoryStore(), 1)
+	root, err := st.Commit(db)
+	if err != nil {
+		t.Fatalf("Commit() failed with %s", err)
+	}
+	omt, err := Open(root, db)
+	if err != nil {
+		t.Fatalf("Open() failed with %s", err)
+	}
+	ost := NewSecureTrie(omt, preimages)
+	err = ost.Put(testKey(100), testValue(100))
+	if err != nil {
+		t.Fatalf("Put(100) failed with %s", err)
+	}
+	if key, err := ost.GetKey(keccak256(testKey(100))); err != nil {
+		t.Errorf("GetKey(100) failed with %s", err)
+	} else if !bytes.Equal(key, testKey(100)) {
+		t.Errorf("GetKey(100): got %v, want %v", key, testKey(100))
+	}
+	_, err = ost.Commit(db)
+	if err != nil {
+		t.Fatalf("Commit() failed with %s", err)
+	}
+
+	sit = NewSecureTrie(mpt, nil).Iterator(nil)
+	if !sit.Next() {
+		t.Fatal("Iterator(): got end")
+	} else if sit.Key() != nil || sit.HashedKey() == nil {
+		t.Errorf("Iterator(): got %v and %x, want nil and a hash", sit.Key(), sit.HashedKey())
+	}
+}
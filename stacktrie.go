@@ -0,0 +1,93 @@
+package mptrie
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrUnsortedKey = errors.New("mptrie: keys must be put in increasing order")
+)
+
+// StackTrie computes the root hash of keys and values which are put in increasing key order.
+// Once no later key can change a subtree, the subtree is replaced by its hash, so memory use
+// is proportional to the length of the keys rather than the number of keys.
+type StackTrie struct {
+	mpt     *MPTrie
+	lastKey []byte
+	started bool
+}
+
+func NewStackTrie() *StackTrie {
+	return &StackTrie{
+		mpt: New(),
+	}
+}
+
+func (st *StackTrie) Put(key, val []byte) error {
+	if st.started && bytes.Compare(key, st.lastKey) <= 0 {
+		return ErrUnsortedKey
+	}
+
+	st.collapse(keyToNibbleKey(key))
+	err := st.mpt.Put(key, val)
+	if err != nil {
+		return err
+	}
+	st.lastKey = append(st.lastKey[:0], key...)
+	st.started = true
+	return nil
+}
+
+func (st *StackTrie) Hash() []byte {
+	return st.mpt.Hash()
+}
+
+// collapse replaces the subtrees to the left of the path to nk by their hashes; since keys are
+// put in increasing order, nothing in them can change.
+func (st *StackTrie) collapse(nk nibbleKey) {
+	n := st.mpt.root
+	for n != nil {
+		if branch, ok := n.(*branchNode); ok {
+			if len(nk) == 0 {
+				return
+			}
+			for ci := 0; ci < int(nk[0]); ci++ {
+				branch.children[ci] = collapseNode(branch.children[ci])
+			}
+
+			n = branch.children[nk[0]]
+			nk = nk[1:]
+		} else if extension, ok := n.(*extensionNode); ok {
+			l := len(extension.subKey)
+			if len(nk) < l || !bytes.Equal(nk[:l], extension.subKey) {
+				extension.child = collapseNode(extension.child)
+				return
+			}
+
+			n = extension.child
+			nk = nk[l:]
+		} else if _, ok := n.(*leafNode); ok {
+			return
+		} else if _, ok := n.(hashNode); ok {
+			return
+		} else {
+			panic(fmt.Sprintf("unexpected mptrie node: %#v", n))
+		}
+	}
+}
+
+// collapseNode returns a hash node for n, unless n is small enough to be included in its
+// parent.
+func collapseNode(n node) node {
+	if n == nil {
+		return nil
+	}
+
+	ref := n.hash(false)
+	if len(ref) < 32 {
+		return n
+	}
+	return hashNode(ref[1:])
+}
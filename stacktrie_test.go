@@ -0,0 +1,81 @@
+package mptrie
+
+import (
+	"bytes"
+	"testing"
+)
+
+func countNodes(n node) int {
+	if n == nil {
+		return 0
+	} else if branch, ok := n.(*branchNode); ok {
+		cnt := 1
+		for _, child := range branch.children {
+			cnt += countNodes(child)
+		}
+		return cnt
+	} else if extension, ok := n.(*extensionNode); ok {
+		return 1 + countNodes(extension.child)
+	}
+	return 1
+}
+
+func TestStackTrie(t *testing.T) {
+	st := NewStackTrie()
+	if !bytes.Equal(st.Hash(), emptyHash) {
+		t.Errorf("Hash(): got %x, want %x", st.Hash(), emptyHash)
+	}
+
+	st = NewStackTrie()
+	for _, kv := range []struct{ k, v string }{
+		{"doe", "reindeer"},
+		{"dog", "puppy"},
+		{"dogglesworth", "cat"},
+	} {
+		err := st.Put([]byte(kv.k), []byte(kv.v))
+		if err != nil {
+			t.Fatalf("Put(%s) failed with %s", kv.k, err)
+		}
+	}
+	want := []byte{0x8a, 0xad, 0x78, 0x9d, 0xff, 0x2f, 0x53, 0x8b, 0xca, 0x5d, 0x8e, 0xa5, 0x6e,
+		0x8a, 0xbe, 0x10, 0xf4, 0xc7, 0xba, 0x3a, 0x5d, 0xea, 0x95, 0xfe, 0xa4, 0xcd, 0x6e, 0x7c,
+		0x3a, 0x11, 0x68, 0xd3}
+	if !bytes.Equal(st.Hash(), want) {
+		t.Errorf("Hash(): got %x, want %x", st.Hash(), want)
+	}
+
+	err := st.Put([]byte("dog"), []byte("puppy"))
+	if err != ErrUnsortedKey {
+		t.Errorf("Put(dog): got %v, want %s", err, ErrUnsortedKey)
+	}
+
+	st = NewStackTrie()
+	mpt := New()
+	maxNodes := 0
+	for i := 0; i < 5000; i++ {
+		key := []byte{byte(i >> 8), byte(i), 0x12}
+		if i%7 == 0 {
+			key = key[:2]
+		}
+		val := bytes.Repeat([]byte{byte(i)}, 1+i%40)
+
+		err := st.Put(key, val)
+		if err != nil {
+			t.Fatalf("Put(%d) failed with %s", i, err)
+		}
+		err = mpt.Put(key, val)
+		if err != nil {
+			t.Fatalf("Put(%d) failed with %s", i, err)
+		}
+
+		if cnt := countNodes(st.mpt.root); cnt > maxNodes {
+			maxNodes = cnt
+		}
+	}
+	if !bytes.Equal(st.Hash(), mpt.Hash()) {
+		t.Errorf("Hash(): got %x, want %x", st.Hash(), mpt.Hash())
+	}
+	if maxNodes > 100 {
+		t.Errorf("StackTrie: got %d nodes, want no more than 100", maxNodes)
+	}
+}
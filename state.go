@@ -0,0 +1,336 @@
+package mptrie
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+)
+
+var (
+	ErrNegativeBalance = errors.New("mptrie: balance must not be negative")
+	emptyCodeHash      = keccak256(nil)
+)
+
+// Account is the state of an Ethereum account as it is stored in the state trie.
+type Account struct {
+	Nonce       uint64
+	Balance     *big.Int
+	StorageRoot []byte
+	CodeHash    []byte
+}
+
+// NewAccount returns an account with no balance, no storage and no code.
+func NewAccount() *Account {
+	return &Account{
+		Balance:     new(big.Int),
+		StorageRoot: emptyHash,
+		CodeHash:    emptyCodeHash,
+	}
+}
+
+func (acct *Account) copy() *Account {
+	return &Account{
+		Nonce:       acct.Nonce,
+		Balance:     new(big.Int).Set(acct.Balance),
+		StorageRoot: acct.StorageRoot,
+		CodeHash:    acct.CodeHash,
+	}
+}
+
+func (acct *Account) empty() bool {
+	return acct.Nonce == 0 && acct.Balance.Sign() == 0 &&
+		bytes.Equal(acct.StorageRoot, emptyHash) && bytes.Equal(acct.CodeHash, emptyCodeHash)
+}
+
+// Encode returns the RLP encoding of the account: a list of the nonce, balance, storage root
+// and code hash.
+func (acct *Account) Encode() []byte {
+	return encodeTuple(nil, encodeScalar(acct.Nonce), encodeBytes(nil, acct.Balance.Bytes()),
+		encodeBytes(nil, acct.StorageRoot), encodeBytes(nil, acct.CodeHash))
+}
+
+// DecodeAccount decodes an account encoded by Encode.
+func DecodeAccount(buf []byte) (*Account, error) {
+	item, err := decodeRLP(buf)
+	if err != nil {
+		return nil, err
+	} else if !item.list || len(item.items) != 4 {
+		return nil, fmt.Errorf("mptrie: bad account: %x", buf)
+	}
+	for _, it := range item.items {
+		if it.list {
+			return nil, fmt.Errorf("mptrie: bad account: %x", buf)
+		}
+	}
+
+	nonce, err := decodeScalar(item.items[0].str)
+	if err != nil {
+		return nil, err
+	}
+	balance := item.items[1].str
+	if len(balance) > 0 && balance[0] == 0 {
+		return nil, fmt.Errorf("mptrie: balance has leading zeros: %x", balance)
+	}
+	if len(item.items[2].str) != 32 || len(item.items[3].str) != 32 {
+		return nil, fmt.Errorf("mptrie: bad account: %x", buf)
+	}
+
+	return &Account{
+		Nonce:       nonce,
+		Balance:     new(big.Int).SetBytes(balance),
+		StorageRoot: append([]byte(nil), item.items[2].str...),
+		CodeHash:    append([]byte(nil), item.items[3].str...),
+	}, nil
+}
+
+type stateAccount struct {
+	acct    *Account
+	storage *SecureTrie // Loaded when the storage of the account is first used.
+	dirty   bool
+}
+
+// State is the Ethereum world state: a secure trie mapping addresses to accounts, each of
+// which has its own secure storage trie. Changes are kept in memory until Hash or Commit.
+type State struct {
+	accounts *SecureTrie
+	store    NodeStore
+	cache    map[string]*stateAccount
+}
+
+// NewState returns the state with the given root hash whose nodes, including the nodes of the
+// storage tries, are loaded from store as they are needed.
+func NewState(root []byte, store NodeStore) (*State, error) {
+	mpt, err := Open(root, store)
+	if err != nil {
+		return nil, err
+	}
+
+	return &State{
+		accounts: NewSecureTrie(mpt, nil),
+		store:    store,
+		cache:    map[string]*stateAccount{},
+	}, nil
+}
+
+func (s *State) account(addr []byte) (*stateAccount, error) {
+	if sa, ok := s.cache[string(addr)]; ok {
+		return sa, nil
+	}
+
+	sa := &stateAccount{}
+	buf, err := s.accounts.Get(addr)
+	if err == ErrNotFound {
+		sa.acct = NewAccount()
+	} else if err != nil {
+		return nil, err
+	} else {
+		sa.acct, err = DecodeAccount(buf)
+		if err != nil {
+			return nil, fmt.Errorf("mptrie: account %x: %w", addr, err)
+		}
+	}
+
+	s.cache[string(addr)] = sa
+	return sa, nil
+}
+
+func (s *State) storage(addr []byte) (*SecureTrie, error) {
+	sa, err := s.account(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	if sa.storage == nil {
+		mpt, err := Open(sa.acct.StorageRoot, s.store)
+		if err != nil {
+			return nil, err
+		}
+		sa.storage = NewSecureTrie(mpt, nil)
+	}
+	return sa.storage, nil
+}
+
+// GetAccount returns a copy of the account at addr; an account which does not exist is empty.
+func (s *State) GetAccount(addr []byte) (*Account, error) {
+	sa, err := s.account(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	acct := sa.acct.copy()
+	if sa.storage != nil {
+		acct.StorageRoot = sa.storage.Hash()
+	}
+	return acct, nil
+}
+
+func (s *State) GetBalance(addr []byte) (*big.Int, error) {
+	sa, err := s.account(addr)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).Set(sa.acct.Balance), nil
+}
+
+func (s *State) SetBalance(addr []byte, balance *big.Int) error {
+	if balance.Sign() < 0 {
+		return ErrNegativeBalance
+	}
+
+	sa, err := s.account(addr)
+	if err != nil {
+		return err
+	}
+	sa.acct.Balance = new(big.Int).Set(balance)
+	sa.dirty = true
+	return nil
+}
+
+func (s *State) GetNonce(addr []byte) (uint64, error) {
+	sa, err := s.account(addr)
+	if err != nil {
+		return 0, err
+	}
+	return sa.acct.Nonce, nil
+}
+
+func (s *State) SetNonce(addr []byte, nonce uint64) error {
+	sa, err := s.account(addr)
+	if err != nil {
+		return err
+	}
+	sa.acct.Nonce = nonce
+	sa.dirty = true
+	return nil
+}
+
+func (s *State) GetCodeHash(addr []byte) ([]byte, error) {
+	sa, err := s.account(addr)
+	if err != nil {
+		return nil, err
+	}
+	return sa.acct.CodeHash, nil
+}
+
+func (s *State) SetCodeHash(addr, codeHash []byte) error {
+	if len(codeHash) != 32 {
+		return fmt.Errorf("mptrie: code hash must be 32 bytes: %x", codeHash)
+	}
+
+	sa, err := s.account(addr)
+	if err != nil {
+		return err
+	}
+	sa.acct.CodeHash = append([]byte(nil), codeHash...)
+	sa.dirty = true
+	return nil
+}
+
+// GetStorage returns the value of slot in the storage of the account at addr, without leading
+// zeros; the value of a slot which has not been set is nil.
+func (s *State) GetStorage(addr, slot []byte) ([]byte, error) {
+	st, err := s.storage(addr)
+	if err != nil {
+		return nil, err
+	}
+
+	buf, err := st.Get(slot)
+	if err == ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	item, err := decodeRLP(buf)
+	if err != nil {
+		return nil, err
+	} else if item.list {
+		return nil, fmt.Errorf("mptrie: bad storage value: %x", buf)
+	}
+	return item.str, nil
+}
+
+// SetStorage sets slot in the storage of the account at addr to val. Values are stored RLP
+// encoded without leading zeros; setting a slot to zero removes it.
+func (s *State) SetStorage(addr, slot, val []byte) error {
+	st, err := s.storage(addr)
+	if err != nil {
+		return err
+	}
+	s.cache[string(addr)].dirty = true
+
+	val = bytes.TrimLeft(val, "\x00")
+	if len(val) == 0 {
+		err = st.Delete(slot)
+		if err == ErrNotFound {
+			return nil
+		}
+		return err
+	}
+	return st.Put(slot, encodeBytes(nil, val))
+}
+
+// update writes the changed accounts to the account trie, setting the storage root of each
+// from its storage trie. Empty accounts are removed.
+func (s *State) update() error {
+	var addrs []string
+	for addr, sa := range s.cache {
+		if sa.dirty {
+			addrs = append(addrs, addr)
+		}
+	}
+	sort.Strings(addrs)
+
+	for _, addr := range addrs {
+		sa := s.cache[addr]
+		if sa.storage != nil {
+			sa.acct.StorageRoot = sa.storage.Hash()
+		}
+
+		var err error
+		if sa.acct.empty() {
+			err = s.accounts.Delete([]byte(addr))
+			if err == ErrNotFound {
+				err = nil
+			}
+		} else {
+			err = s.accounts.Put([]byte(addr), sa.acct.Encode())
+		}
+		if err != nil {
+			return err
+		}
+		sa.dirty = false
+	}
+
+	return nil
+}
+
+// Hash returns the root hash of the state.
+func (s *State) Hash() ([]byte, error) {
+	err := s.update()
+	if err != nil {
+		return nil, err
+	}
+	return s.accounts.Hash(), nil
+}
+
+// Commit writes the changed nodes of the storage tries and of the account trie to the store
+// of the state, and returns the root hash of the state.
+func (s *State) Commit() ([]byte, error) {
+	err := s.update()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, sa := range s.cache {
+		if sa.storage != nil {
+			_, err := sa.storage.Commit(s.store)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return s.accounts.Commit(s.store)
+}
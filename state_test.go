@@ -0,0 +1,190 @@
+package mptrie
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math/big"
+	"testing"
+)
+
+func TestAccount(t *testing.T) {
+	want, _ := hex.DecodeString("f8448080a056e81f171bcc55a6ff8345e692c0f86e5b48e01b996cadc001622" +
+		"fb5e363b421a0c5d2460186f7233c927e7db2dcc703c0e500b653ca82273b7bfad8045d85a470")
+	buf := NewAccount().Encode()
+	if !bytes.Equal(buf, want) {
+		t.Errorf("NewAccount().Encode(): got %x, want %x", buf, want)
+	}
+
+	acct := &Account{
+		Nonce:       0x1234,
+		Balance:     new(big.Int).Lsh(big.NewInt(1), 100),
+		StorageRoot: keccak256([]byte("storage")),
+		CodeHash:    keccak256([]byte("code")),
+	}
+	dec, err := DecodeAccount(acct.Encode())
+	if err != nil {
+		t.Fatalf("DecodeAccount() failed with %s", err)
+	}
+	if dec.Nonce != acct.Nonce || dec.Balance.Cmp(acct.Balance) != 0 ||
+		!bytes.Equal(dec.StorageRoot, acct.StorageRoot) ||
+		!bytes.Equal(dec.CodeHash, acct.CodeHash) {
+
+		t.Errorf("DecodeAccount(): got %v, want %v", dec, acct)
+	}
+
+	for _, buf := range [][]byte{
+		encodeTuple(nil, encodeBytes(nil, []byte{0x00, 0x01}), emptyBytes,
+			encodeBytes(nil, emptyHash), encodeBytes(nil, emptyCodeHash)),
+		encodeTuple(nil, emptyBytes, encodeBytes(nil, []byte{0x00, 0x01}),
+			encodeBytes(nil, emptyHash), encodeBytes(nil, emptyCodeHash)),
+		encodeTuple(nil, emptyBytes, emptyBytes, encodeBytes(nil, emptyHash[1:]),
+			encodeBytes(nil, emptyCodeHash)),
+		encodeTuple(nil, emptyBytes, emptyBytes, encodeBytes(nil, emptyHash)),
+		encodeTuple(nil, encodeTuple(nil), emptyBytes, encodeBytes(nil, emptyHash),
+			encodeBytes(nil, emptyCodeHash)),
+		encodeBytes(nil, emptyHash),
+	} {
+		_, err := DecodeAccount(buf)
+		if err == nil {
+			t.Errorf("DecodeAccount(%x) did not fail", buf)
+		}
+	}
+}
+
+func TestState(t *testing.T) {
+	store := NewMemoryStore()
+	s, err := NewState(emptyHash, store)
+	if err != nil {
+		t.Fatalf("NewState() failed with %s", err)
+	}
+
+	addr1 := bytes.Repeat([]byte{0x11}, 20)
+	addr2 := bytes.Repeat([]byte{0x22}, 20)
+	addr3 := bytes.Repeat([]byte{0x33}, 20)
+	slot1 := bytes.Repeat([]byte{0x01}, 32)
+	slot2 := bytes.Repeat([]byte{0x02}, 32)
+
+	err = s.SetBalance(addr1, big.NewInt(1000))
+	if err != nil {
+		t.Fatalf("SetBalance() failed with %s", err)
+	}
+	err = s.SetNonce(addr1, 7)
+	if err != nil {
+		t.Fatalf("SetNonce() failed with %s", err)
+	}
+	err = s.SetStorage(addr2, slot1, append(make([]byte, 31), 0x2A))
+	if err != nil {
+		t.Fatalf("SetStorage() failed with %s", err)
+	}
+	err = s.SetStorage(addr2, slot2, []byte{0x01, 0x02})
+	if err != nil {
+		t.Fatalf("SetStorage() failed with %s", err)
+	}
+	err = s.SetStorage(addr2, slot2, make([]byte, 32))
+	if err != nil {
+		t.Fatalf("SetStorage() failed with %s", err)
+	}
+	err = s.SetBalance(addr3, big.NewInt(0))
+	if err != nil {
+		t.Fatalf("SetBalance() failed with %s", err)
+	}
+	err = s.SetBalance(addr3, big.NewInt(-1))
+	if err != ErrNegativeBalance {
+		t.Errorf("SetBalance(-1): got %v, want %s", err, ErrNegativeBalance)
+	}
+
+	// Build the same state directly from secure tries.
+	storage := NewSecureTrie(New(), nil)
+	err = storage.Put(slot1, []byte{0x2A})
+	if err != nil {
+		t.Fatalf("Put() failed with %s", err)
+	}
+	accounts := NewSecureTrie(New(), nil)
+	acct := NewAccount()
+	acct.Nonce = 7
+	acct.Balance = big.NewInt(1000)
+	err = accounts.Put(addr1, acct.Encode())
+	if err != nil {
+		t.Fatalf("Put() failed with %s", err)
+	}
+	acct = NewAccount()
+	acct.StorageRoot = storage.Hash()
+	err = accounts.Put(addr2, acct.Encode())
+	if err != nil {
+		t.Fatalf("Put() failed with %s", err)
+	}
+
+	h, err := s.Hash()
+	if err != nil {
+		t.Fatalf("Hash() failed with %s", err)
+	} else if !bytes.Equal(h, accounts.Hash()) {
+		t.Errorf("Hash(): got %x, want %x", h, accounts.Hash())
+	}
+	root, err := s.Commit()
+	if err != nil {
+		t.Fatalf("Commit() failed with %s", err)
+	} else if !bytes.Equal(root, h) {
+		t.Errorf("Commit(): got %x, want %x", root, h)
+	}
+
+	s, err = NewState(root, store)
+	if err != nil {
+		t.Fatalf("NewState() failed with %s", err)
+	}
+	balance, err := s.GetBalance(addr1)
+	if err != nil {
+		t.Fatalf("GetBalance() failed with %s", err)
+	} else if balance.Cmp(big.NewInt(1000)) != 0 {
+		t.Errorf("GetBalance(): got %s, want 1000", balance)
+	}
+	nonce, err := s.GetNonce(addr1)
+	if err != nil {
+		t.Fatalf("GetNonce() failed with %s", err)
+	} else if nonce != 7 {
+		t.Errorf("GetNonce(): got %d, want 7", nonce)
+	}
+	val, err := s.GetStorage(addr2, slot1)
+	if err != nil {
+		t.Fatalf("GetStorage() failed with %s", err)
+	} else if !bytes.Equal(val, []byte{0x2A}) {
+		t.Errorf("GetStorage(): got %x, want 2a", val)
+	}
+	val, err = s.GetStorage(addr2, slot2)
+	if err != nil {
+		t.Fatalf("GetStorage() failed with %s", err)
+	} else if val != nil {
+		t.Errorf("GetStorage(): got %x, want nil", val)
+	}
+	acct, err = s.GetAccount(addr3)
+	if err != nil {
+		t.Fatalf("GetAccount() failed with %s", err)
+	} else if !acct.empty() {
+		t.Errorf("GetAccount(): got %v, want empty", acct)
+	}
+	codeHash, err := s.GetCodeHash(addr1)
+	if err != nil {
+		t.Fatalf("GetCodeHash() failed with %s", err)
+	} else if !bytes.Equal(codeHash, emptyCodeHash) {
+		t.Errorf("GetCodeHash(): got %x, want %x", codeHash, emptyCodeHash)
+	}
+
+	// Clearing the last storage slot and the balance removes the accounts.
+	err = s.SetStorage(addr2, slot1, nil)
+	if err != nil {
+		t.Fatalf("SetStorage() failed with %s", err)
+	}
+	err = s.SetBalance(addr1, new(big.Int))
+	if err != nil {
+		t.Fatalf("SetBalance() failed with %s", err)
+	}
+	err = s.SetNonce(addr1, 0)
+	if err != nil {
+		t.Fatalf("SetNonce() failed with %s", err)
+	}
+	h, err = s.Hash()
+	if err != nil {
+		t.Fatalf("Hash() failed with %s", err)
+	} else if !bytes.Equal(h, emptyHash) {
+		t.Errorf("Hash(): got %x, want %x", h, emptyHash)
+	}
+}
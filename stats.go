@@ -0,0 +1,164 @@
+package mptrie
+
+import (
+	"fmt"
+	"strings"
+)
+
+const maxLargestValues = 10
+
+// ValueSize is the size of the value of a key.
+type ValueSize struct {
+	Key  []byte
+	Size int
+}
+
+// Stats describes the shape of a trie.
+type Stats struct {
+	Leaves     int
+	Extensions int
+	Branches   int
+
+	// Depths[d] is the number of values which are d nodes below the root.
+	Depths []int
+
+	// FanOut[c] is the number of branches with c children.
+	FanOut [17]int
+
+	// EncodedSize is the total size of the encodings of the root and of every node which is
+	// referred to by its hash; the other nodes are included in the encodings of their parents.
+	EncodedSize int64
+
+	InlineChildren int
+	HashedChildren int
+
+	// LargestValues holds the keys with the largest values, largest first.
+	LargestValues []ValueSize
+}
+
+// Stats walks the trie, loading nodes from its store as needed, and returns its statistics.
+func (mpt *MPTrie) Stats() (*Stats, error) {
+	st := &Stats{}
+	if mpt.root == nil {
+		return st, nil
+	}
+
+	err := mpt.statsNode(st, mpt.root, nil, 0, true)
+	if err != nil {
+		return nil, err
+	}
+	return st, nil
+}
+
+// StoreStats returns the statistics of the trie with the given root hash in store.
+func StoreStats(root []byte, store NodeStore) (*Stats, error) {
+	mpt, err := Open(root, store)
+	if err != nil {
+		return nil, err
+	}
+	return mpt.Stats()
+}
+
+func (st *Stats) addValue(path nibbleKey, depth int, val []byte) {
+	for len(st.Depths) <= depth {
+		st.Depths = append(st.Depths, 0)
+	}
+	st.Depths[depth] += 1
+
+	i := len(st.LargestValues)
+	for i > 0 && st.LargestValues[i-1].Size < len(val) {
+		i -= 1
+	}
+	if i < maxLargestValues {
+		st.LargestValues = append(st.LargestValues, ValueSize{})
+		copy(st.LargestValues[i+1:], st.LargestValues[i:])
+		st.LargestValues[i] = ValueSize{Key: nibbleKeyToKey(path), Size: len(val)}
+		if len(st.LargestValues) > maxLargestValues {
+			st.LargestValues = st.LargestValues[:maxLargestValues]
+		}
+	}
+}
+
+func (mpt *MPTrie) statsChild(st *Stats, n node, path nibbleKey, depth int) error {
+	if len(n.hash(false)) < 32 {
+		st.InlineChildren += 1
+	} else {
+		st.HashedChildren += 1
+	}
+	return mpt.statsNode(st, n, path, depth, false)
+}
+
+func (mpt *MPTrie) statsNode(st *Stats, n node, path nibbleKey, depth int, root bool) error {
+	if hn, ok := n.(hashNode); ok {
+		var err error
+		n, err = mpt.resolve(hn, path)
+		if err != nil {
+			return err
+		}
+	}
+
+	if buf := n.encode(); root || len(buf) >= 32 {
+		st.EncodedSize += int64(len(buf))
+	}
+
+	if branch, ok := n.(*branchNode); ok {
+		st.Branches += 1
+		if branch.value != nil {
+			st.addValue(path, depth, branch.value)
+		}
+
+		var cnt int
+		for ci, child := range branch.children {
+			if child == nil {
+				continue
+			}
+
+			cnt += 1
+			err := mpt.statsChild(st, child, joinNibbleKeys(path, nibbleKey{byte(ci)}),
+				depth+1)
+			if err != nil {
+				return err
+			}
+		}
+		st.FanOut[cnt] += 1
+	} else if extension, ok := n.(*extensionNode); ok {
+		st.Extensions += 1
+		return mpt.statsChild(st, extension.child, joinNibbleKeys(path, extension.subKey),
+			depth+1)
+	} else if leaf, ok := n.(*leafNode); ok {
+		st.Leaves += 1
+		st.addValue(joinNibbleKeys(path, leaf.suffixKey), depth, leaf.value)
+	} else {
+		panic(fmt.Sprintf("unexpected mptrie node: %#v", n))
+	}
+
+	return nil
+}
+
+func (st *Stats) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "nodes: %d leaves, %d extensions, %d branches\n", st.Leaves,
+		st.Extensions, st.Branches)
+	fmt.Fprintf(&sb, "encoded size: %d bytes\n", st.EncodedSize)
+	fmt.Fprintf(&sb, "children: %d inline, %d hashed\n", st.InlineChildren, st.HashedChildren)
+
+	fmt.Fprintln(&sb, "depths:")
+	for d, cnt := range st.Depths {
+		if cnt > 0 {
+			fmt.Fprintf(&sb, "  %2d: %d\n", d, cnt)
+		}
+	}
+
+	fmt.Fprintln(&sb, "branch fan-out:")
+	for c, cnt := range st.FanOut {
+		if cnt > 0 {
+			fmt.Fprintf(&sb, "  %2d: %d\n", c, cnt)
+		}
+	}
+
+	fmt.Fprintln(&sb, "largest values:")
+	for _, vs := range st.LargestValues {
+		fmt.Fprintf(&sb, "  %x: %d bytes\n", vs.Key, vs.Size)
+	}
+	return sb.String()
+}
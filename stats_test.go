@@ -0,0 +1,94 @@
+package mptrie
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestStats(t *testing.T) {
+	st, err := New().Stats()
+	if err != nil {
+		t.Fatalf("Stats() failed with %s", err)
+	} else if !reflect.DeepEqual(st, &Stats{}) {
+		t.Errorf("Stats(): got %#v, want empty", st)
+	}
+
+	mpt := New()
+	for _, kv := range [][2]string{
+		{"doe", "reindeer"},
+		{"dog", "puppy"},
+		{"dogglesworth", "cat"},
+	} {
+		err := mpt.Put([]byte(kv[0]), []byte(kv[1]))
+		if err != nil {
+			t.Fatalf("Put(%s) failed with %s", kv[0], err)
+		}
+	}
+
+	store := NewMemoryStore()
+	root, err := mpt.Commit(store)
+	if err != nil {
+		t.Fatalf("Commit() failed with %s", err)
+	}
+	var size int64
+	for _, buf := range store.nodes {
+		size += int64(len(buf))
+	}
+
+	// The root is an extension to a branch for "do" which has a leaf for "doe" and a branch
+	// for "dog" which has a leaf for "dogglesworth"; both leaves are inline.
+	want := &Stats{
+		Leaves:         2,
+		Extensions:     1,
+		Branches:       2,
+		Depths:         []int{0, 0, 2, 1},
+		EncodedSize:    size,
+		InlineChildren: 2,
+		HashedChildren: 2,
+		LargestValues: []ValueSize{
+			{Key: []byte("doe"), Size: 8},
+			{Key: []byte("dog"), Size: 5},
+			{Key: []byte("dogglesworth"), Size: 3},
+		},
+	}
+	want.FanOut[1] = 1
+	want.FanOut[2] = 1
+
+	st, err = mpt.Stats()
+	if err != nil {
+		t.Fatalf("Stats() failed with %s", err)
+	} else if !reflect.DeepEqual(st, want) {
+		t.Errorf("Stats(): got %#v, want %#v", st, want)
+	}
+
+	st, err = StoreStats(root, store)
+	if err != nil {
+		t.Fatalf("StoreStats() failed with %s", err)
+	} else if !reflect.DeepEqual(st, want) {
+		t.Errorf("StoreStats(): got %#v, want %#v", st, want)
+	}
+
+	mpt = New()
+	for i := 0; i < 100; i++ {
+		err := mpt.Put(testKey(i), testValue(i))
+		if err != nil {
+			t.Fatalf("Put(%d) failed with %s", i, err)
+		}
+	}
+	st, err = mpt.Stats()
+	if err != nil {
+		t.Fatalf("Stats() failed with %s", err)
+	}
+	if len(st.LargestValues) != maxLargestValues {
+		t.Fatalf("Stats(): got %d largest values, want %d", len(st.LargestValues),
+			maxLargestValues)
+	}
+	for i, vs := range st.LargestValues {
+		if vs.Size != 48-i/2 {
+			t.Errorf("Stats().LargestValues[%d]: got %d bytes, want %d", i, vs.Size, 48-i/2)
+		}
+	}
+	if st.String() == "" {
+		t.Error("Stats().String(): got empty string")
+	}
+}
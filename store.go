@@ -0,0 +1,251 @@
+package mptrie
+
+import "fmt"
+
+// MissingNodeError is returned when a node needed by an operation on a trie is not available.
+type MissingNodeError struct {
+	Hash []byte
+	Path []byte // The nibbles of the path from the root to the missing node.
+}
+
+func (err *MissingNodeError) Error() string {
+	return fmt.Sprintf("mptrie: missing node %x at path %x", err.Hash, err.Path)
+}
+
+// NodeStore persists the encodings of nodes using their keccak256 hash as the key. Get must
+// return ErrNotFound if there is no node with hash in the store. A store must never hold a
+// node without the nodes it refers to. A store may also have a Has(hash []byte) (bool, error)
+// method, which Commit uses to check for a node without reading it.
+type NodeStore interface {
+	Get(hash []byte) ([]byte, error)
+	Put(hash, buf []byte) error
+}
+
+type nodeChecker interface {
+	Has(hash []byte) (bool, error)
+}
+
+// hasNode returns whether store holds the node with hash.
+func hasNode(store NodeStore, hash []byte) (bool, error) {
+	if nc, ok := store.(nodeChecker); ok {
+		return nc.Has(hash)
+	}
+
+	_, err := store.Get(hash)
+	if err == ErrNotFound {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+type MemoryStore struct {
+	nodes map[string][]byte
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		nodes: map[string][]byte{},
+	}
+}
+
+func (ms *MemoryStore) Get(hash []byte) ([]byte, error) {
+	buf, ok := ms.nodes[string(hash)]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return buf, nil
+}
+
+func (ms *MemoryStore) Has(hash []byte) (bool, error) {
+	_, ok := ms.nodes[string(hash)]
+	return ok, nil
+}
+
+func (ms *MemoryStore) Put(hash, buf []byte) error {
+	ms.nodes[string(hash)] = append([]byte(nil), buf...)
+	return nil
+}
+
+func (ms *MemoryStore) Sweep(keep map[string]struct{}) (int64, error) {
+	var reclaimed int64
+	for hash, buf := range ms.nodes {
+		if _, ok := keep[hash]; !ok {
+			reclaimed += int64(len(hash) + len(buf))
+			delete(ms.nodes, hash)
+		}
+	}
+	return reclaimed, nil
+}
+
+func (ms *MemoryStore) Len() int {
+	return len(ms.nodes)
+}
+
+// Commit writes every node which is not already in store, and whose encoding is at least 32
+// bytes, to store; the root is always written. Nodes which have not changed since they were
+// committed or loaded are only checked for, and those which have not been loaded are copied
+// from the trie's own store if they are missing from store. It returns the root hash.
+func (mpt *MPTrie) Commit(store NodeStore) ([]byte, error) {
+	if mpt.root == nil {
+		return emptyHash, nil
+	}
+
+	err := mpt.commitNode(store, mpt.root, nil, true)
+	if err != nil {
+		return nil, err
+	}
+	return mpt.Hash(), nil
+}
+
+func (mpt *MPTrie) commitNode(store NodeStore, n node, path nibbleKey, root bool) error {
+	if hn, ok := n.(hashNode); ok {
+		has, err := hasNode(store, hn)
+		if err != nil || has {
+			return err
+		}
+
+		// Copy the missing node, and the nodes below it, from the trie's own store without
+		// keeping them in the trie.
+		n, err = mpt.resolve(hn, path)
+		if err != nil {
+			return err
+		}
+	} else if isStored(n) {
+		if !root && len(n.hash(false)) < 32 {
+			// Small nodes are included in their parent, and so are the nodes below them.
+			return nil
+		}
+
+		// If store has the node, it has the nodes below it as well.
+		has, err := hasNode(store, n.hash(true))
+		if err != nil || has {
+			return err
+		}
+	}
+
+	if branch, ok := n.(*branchNode); ok {
+		for ci, child := range branch.children {
+			if child != nil {
+				err := mpt.commitNode(store, child, joinNibbleKeys(path, nibbleKey{byte(ci)}),
+					false)
+				if err != nil {
+					return err
+				}
+			}
+		}
+	} else if extension, ok := n.(*extensionNode); ok {
+		err := mpt.commitNode(store, extension.child, joinNibbleKeys(path, extension.subKey),
+			false)
+		if err != nil {
+			return err
+		}
+	} else if _, ok := n.(*leafNode); !ok {
+		panic(fmt.Sprintf("unexpected mptrie node: %#v", n))
+	}
+
+	err := putNode(store, n, root)
+	if err != nil {
+		return err
+	}
+	markStored(n)
+	return nil
+}
+
+func putNode(store NodeStore, n node, root bool) error {
+	if !root && len(n.hash(false)) < 32 {
+		// Small nodes are included in their parent.
+		return nil
+	}
+	return store.Put(n.hash(true), n.encode())
+}
+
+// resolve loads the node with hash hn, found at path, from the store.
+func (mpt *MPTrie) resolve(hn hashNode, path nibbleKey) (node, error) {
+	var buf []byte
+	var err error
+	if mpt.pathStore != nil {
+		buf, err = mpt.pathStore.get(mpt.owner, path, hn)
+	} else if mpt.store != nil {
+		buf, err = mpt.store.Get(hn)
+	} else {
+		err = ErrNotFound
+	}
+	if err == ErrNotFound {
+		return nil, &MissingNodeError{Hash: hn, Path: path}
+	} else if err != nil {
+		return nil, err
+	}
+
+	n, err := decodeNode(buf)
+	if err != nil {
+		return nil, fmt.Errorf("mptrie: node %x at path %x: %w", []byte(hn), []byte(path), err)
+	}
+
+	if len(buf) < 32 {
+		setStored(n, buf)
+	} else {
+		setStored(n, encodeBytes(nil, hn))
+	}
+	return n, nil
+}
+
+// resolveChild replaces *pn with the node it refers to, if it is a hash node.
+func (mpt *MPTrie) resolveChild(pn *node, path nibbleKey) error {
+	hn, ok := (*pn).(hashNode)
+	if !ok {
+		return nil
+	}
+
+	n, err := mpt.resolve(hn, path)
+	if err != nil {
+		return err
+	}
+	*pn = n
+	return nil
+}
+
+// setStored marks a node loaded from the store, and the nodes included in it, as stored; ref
+// may be nil.
+func setStored(n node, ref []byte) {
+	if branch, ok := n.(*branchNode); ok {
+		branch.ref = ref
+		branch.stored = true
+		for _, child := range branch.children {
+			if child != nil {
+				setStored(child, nil)
+			}
+		}
+	} else if extension, ok := n.(*extensionNode); ok {
+		extension.ref = ref
+		extension.stored = true
+		setStored(extension.child, nil)
+	} else if leaf, ok := n.(*leafNode); ok {
+		leaf.ref = ref
+		leaf.stored = true
+	}
+}
+
+// isStored returns whether n has not changed since it was committed or loaded. The store it
+// was committed to may have lost it since, so callers must still check for it.
+func isStored(n node) bool {
+	if branch, ok := n.(*branchNode); ok {
+		return branch.stored
+	} else if extension, ok := n.(*extensionNode); ok {
+		return extension.stored
+	} else if leaf, ok := n.(*leafNode); ok {
+		return leaf.stored
+	}
+	return true
+}
+
+func markStored(n node) {
+	if branch, ok := n.(*branchNode); ok {
+		branch.stored = true
+	} else if extension, ok := n.(*extensionNode); ok {
+		extension.stored = true
+	} else if leaf, ok := n.(*leafNode); ok {
+		leaf.stored = true
+	}
+}
@@ -0,0 +1,377 @@
+package mptrie
+
+import (
+	"bytes"
+	"testing"
+)
+
+type countingStore struct {
+	*MemoryStore
+	puts int
+}
+
+func (cs *countingStore) Put(hash, buf []byte) error {
+	cs.puts += 1
+	return cs.MemoryStore.Put(hash, buf)
+}
+
+func TestCommit(t *testing.T) {
+	store := &countingStore{MemoryStore: NewMemoryStore()}
+
+	mpt := New()
+	h, err := mpt.Commit(store)
+	if err != nil {
+		t.Fatalf("Commit() failed with %s", err)
+	} else if !bytes.Equal(h, emptyHash) {
+		t.Errorf("Commit(): got %v, want %v", h, emptyHash)
+	} else if store.puts != 0 {
+		t.Errorf("Commit(): got %d puts, want 0", store.puts)
+	}
+
+	err = mpt.Put([]byte{0x01}, []byte{0x02})
+	if err != nil {
+		t.Fatalf("Put() failed with %s", err)
+	}
+	h, err = mpt.Commit(store)
+	if err != nil {
+		t.Fatalf("Commit() failed with %s", err)
+	}
+	buf, err := store.Get(h)
+	if err != nil {
+		t.Errorf("Get(%v) failed with %s", h, err)
+	} else if !bytes.Equal(buf, mpt.root.encode()) {
+		t.Errorf("Get(%v): got %v, want %v", h, buf, mpt.root.encode())
+	}
+
+	for i := 0; i < 256; i++ {
+		err := mpt.Put([]byte{byte(i), 0x12, 0x34}, bytes.Repeat([]byte{byte(i)}, 32))
+		if err != nil {
+			t.Fatalf("Put(%d) failed with %s", i, err)
+		}
+	}
+
+	store.puts = 0
+	h, err = mpt.Commit(store)
+	if err != nil {
+		t.Fatalf("Commit() failed with %s", err)
+	} else if !bytes.Equal(h, mpt.Hash()) {
+		t.Errorf("Commit(): got %v, want %v", h, mpt.Hash())
+	} else if store.puts != 1+16+1+256 {
+		// The root, 16 branches, the branch with the value of {0x01}, and 256 leaves.
+		t.Errorf("Commit(): got %d puts, want %d", store.puts, 1+16+1+256)
+	}
+
+	for i := 0; i < 256; i++ {
+		proof, err := mpt.Prove([]byte{byte(i), 0x12, 0x34})
+		if err != nil {
+			t.Fatalf("Prove(%d) failed with %s", i, err)
+		}
+		for _, want := range proof {
+			buf, err := store.Get(keccak256(want))
+			if err != nil {
+				t.Errorf("Get(%v) failed with %s", keccak256(want), err)
+			} else if !bytes.Equal(buf, want) {
+				t.Errorf("Get(%v): got %v, want %v", keccak256(want), buf, want)
+			}
+		}
+	}
+
+	err = mpt.Put([]byte{0x5A, 0x12, 0x34}, []byte{0x5A})
+	if err != nil {
+		t.Fatalf("Put() failed with %s", err)
+	}
+	store.puts = 0
+	_, err = mpt.Commit(store)
+	if err != nil {
+		t.Fatalf("Commit() failed with %s", err)
+	} else if store.puts != 2 {
+		t.Errorf("Commit(): got %d puts, want 2", store.puts)
+	}
+}
+
+func testKey(i int) []byte {
+	return []byte{byte(i), byte(i * 7), 0x12, byte(i % 3)}
+}
+
+func testValue(i int) []byte {
+	return bytes.Repeat([]byte{byte(i)}, 1+i%48)
+}
+
+func TestOpen(t *testing.T) {
+	_, err := Open([]byte{0x01, 0x02}, NewMemoryStore())
+	if err == nil {
+		t.Error("Open() with a short root did not fail")
+	}
+
+	mpt, err := Open(emptyHash, NewMemoryStore())
+	if err != nil {
+		t.Fatalf("Open() failed with %s", err)
+	} else if mpt.root != nil {
+		t.Errorf("Open(emptyHash): got root %#v, want nil", mpt.root)
+	}
+
+	store := NewMemoryStore()
+	mpt = New()
+	for i := 0; i < 500; i++ {
+		err := mpt.Put(testKey(i), testValue(i))
+		if err != nil {
+			t.Fatalf("Put(%d) failed with %s", i, err)
+		}
+	}
+	root, err := mpt.Commit(store)
+	if err != nil {
+		t.Fatalf("Commit() failed with %s", err)
+	}
+
+	omt, err := Open(root, store)
+	if err != nil {
+		t.Fatalf("Open() failed with %s", err)
+	} else if _, ok := omt.root.(hashNode); !ok {
+		t.Errorf("Open(): got root %#v, want hash node", omt.root)
+	}
+	if !bytes.Equal(omt.Hash(), root) {
+		t.Errorf("Hash(): got %v, want %v", omt.Hash(), root)
+	}
+
+	it := omt.Iterator(nil)
+	cnt := 0
+	for it.Next() {
+		cnt += 1
+	}
+	if it.Err() != nil {
+		t.Errorf("Iterator() failed with %s", it.Err())
+	} else if cnt != 500 {
+		t.Errorf("Iterator(): got %d keys, want 500", cnt)
+	}
+
+	for i := 0; i < 500; i++ {
+		val, err := omt.Get(testKey(i))
+		if err != nil {
+			t.Errorf("Get(%d) failed with %s", i, err)
+		} else if !bytes.Equal(val, testValue(i)) {
+			t.Errorf("Get(%d): got %v, want %v", i, val, testValue(i))
+		}
+	}
+
+	omt, err = Open(root, store)
+	if err != nil {
+		t.Fatalf("Open() failed with %s", err)
+	}
+	for i := 0; i < 500; i += 3 {
+		err := mpt.Delete(testKey(i))
+		if err != nil {
+			t.Fatalf("Delete(%d) failed with %s", i, err)
+		}
+		err = omt.Delete(testKey(i))
+		if err != nil {
+			t.Fatalf("Delete(%d) failed with %s", i, err)
+		}
+	}
+	for i := 500; i < 600; i++ {
+		err := mpt.Put(testKey(i), testValue(i))
+		if err != nil {
+			t.Fatalf("Put(%d) failed with %s", i, err)
+		}
+		err = omt.Put(testKey(i), testValue(i))
+		if err != nil {
+			t.Fatalf("Put(%d) failed with %s", i, err)
+		}
+	}
+	if !bytes.Equal(omt.Hash(), mpt.Hash()) {
+		t.Errorf("Hash(): got %v, want %v", omt.Hash(), mpt.Hash())
+	}
+}
+
+func countStoredKeys(t *testing.T, store NodeStore, root []byte) int {
+	t.Helper()
+
+	mpt, err := Open(root, store)
+	if err != nil {
+		t.Fatalf("Open() failed with %s", err)
+	}
+	it := mpt.Iterator(nil)
+	cnt := 0
+	for it.Next() {
+		cnt += 1
+	}
+	if it.Err() != nil {
+		t.Fatalf("Iterator() failed with %s", it.Err())
+	}
+	return cnt
+}
+
+func TestCommitStores(t *testing.T) {
+	mpt := New()
+	for i := 0; i < 500; i++ {
+		err := mpt.Put(testKey(i), testValue(i))
+		if err != nil {
+			t.Fatalf("Put(%d) failed with %s", i, err)
+		}
+	}
+
+	// The same trie committed to two stores.
+	store1 := NewMemoryStore()
+	store2 := NewMemoryStore()
+	for _, store := range []*MemoryStore{store1, store2} {
+		root, err := mpt.Commit(store)
+		if err != nil {
+			t.Fatalf("Commit() failed with %s", err)
+		}
+		if cnt := countStoredKeys(t, store, root); cnt != 500 {
+			t.Errorf("Commit(): got %d keys, want 500", cnt)
+		}
+	}
+	if store1.Len() != store2.Len() {
+		t.Errorf("Commit(): got %d nodes, want %d", store2.Len(), store1.Len())
+	}
+
+	// A clone committed to one store, and then the original to another.
+	clone := mpt.Clone()
+	err := clone.Put(testKey(500), testValue(500))
+	if err != nil {
+		t.Fatalf("Put(500) failed with %s", err)
+	}
+	err = mpt.Put(testKey(501), testValue(501))
+	if err != nil {
+		t.Fatalf("Put(501) failed with %s", err)
+	}
+	root, err := clone.Commit(NewMemoryStore())
+	if err != nil {
+		t.Fatalf("Commit() failed with %s", err)
+	}
+	store3 := NewMemoryStore()
+	root, err = mpt.Commit(store3)
+	if err != nil {
+		t.Fatalf("Commit() failed with %s", err)
+	}
+	if cnt := countStoredKeys(t, store3, root); cnt != 501 {
+		t.Errorf("Commit(): got %d keys, want 501", cnt)
+	}
+
+	// A trie loaded from one store, changed, and committed to another.
+	omt, err := Open(root, store3)
+	if err != nil {
+		t.Fatalf("Open() failed with %s", err)
+	}
+	err = omt.Put(testKey(502), testValue(502))
+	if err != nil {
+		t.Fatalf("Put(502) failed with %s", err)
+	}
+	store4 := NewMemoryStore()
+	root, err = omt.Commit(store4)
+	if err != nil {
+		t.Fatalf("Commit() failed with %s", err)
+	}
+	if cnt := countStoredKeys(t, store4, root); cnt != 502 {
+		t.Errorf("Commit(): got %d keys, want 502", cnt)
+	}
+}
+
+// mapStore is a NodeStore which is not comparable.
+type mapStore map[string][]byte
+
+func (ms mapStore) Get(hash []byte) ([]byte, error) {
+	buf, ok := ms[string(hash)]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return buf, nil
+}
+
+func (ms mapStore) Put(hash, buf []byte) error {
+	ms[string(hash)] = append([]byte(nil), buf...)
+	return nil
+}
+
+func TestCommitMapStore(t *testing.T) {
+	mpt := New()
+	for i := 0; i < 300; i++ {
+		err := mpt.Put(testKey(i), testValue(i))
+		if err != nil {
+			t.Fatalf("Put(%d) failed with %s", i, err)
+		}
+	}
+
+	store := mapStore{}
+	root, err := mpt.Commit(store)
+	if err != nil {
+		t.Fatalf("Commit() failed with %s", err)
+	}
+	omt, err := Open(root, store)
+	if err != nil {
+		t.Fatalf("Open() failed with %s", err)
+	}
+	err = omt.Put(testKey(300), testValue(300))
+	if err != nil {
+		t.Fatalf("Put(300) failed with %s", err)
+	}
+	root, err = omt.Commit(store)
+	if err != nil {
+		t.Fatalf("Commit() failed with %s", err)
+	}
+	if cnt := countStoredKeys(t, store, root); cnt != 301 {
+		t.Errorf("Commit(): got %d keys, want 301", cnt)
+	}
+}
+
+func TestMissingNode(t *testing.T) {
+	key1 := []byte{0x10}
+	key2 := []byte{0x20}
+	key3 := []byte{0x30}
+
+	mpt := New()
+	for _, key := range [][]byte{key1, key2, key3} {
+		err := mpt.Put(key, bytes.Repeat(key, 40))
+		if err != nil {
+			t.Fatalf("Put(%v) failed with %s", key, err)
+		}
+	}
+	store := NewMemoryStore()
+	root, err := mpt.Commit(store)
+	if err != nil {
+		t.Fatalf("Commit() failed with %s", err)
+	}
+
+	branch := mpt.root.(*branchNode)
+	missing := branch.children[3].hash(true)
+	delete(store.nodes, string(missing))
+
+	omt, err := Open(root, store)
+	if err != nil {
+		t.Fatalf("Open() failed with %s", err)
+	}
+
+	_, err = omt.Get(key3)
+	if merr, ok := err.(*MissingNodeError); !ok {
+		t.Errorf("Get(%v): got %v, want missing node", key3, err)
+	} else if !bytes.Equal(merr.Hash, missing) || !bytes.Equal(merr.Path, []byte{0x03}) {
+		t.Errorf("Get(%v): got %s", key3, err)
+	}
+
+	err = omt.Delete(key1)
+	if err != nil {
+		t.Fatalf("Delete(%v) failed with %s", key1, err)
+	}
+	h := omt.Hash()
+
+	err = omt.Delete(key2)
+	if _, ok := err.(*MissingNodeError); !ok {
+		t.Errorf("Delete(%v): got %v, want missing node", key2, err)
+	}
+	if !bytes.Equal(omt.Hash(), h) {
+		t.Errorf("Delete(%v) changed the trie", key2)
+	}
+	val, err := omt.Get(key2)
+	if err != nil {
+		t.Errorf("Get(%v) failed with %s", key2, err)
+	} else if !bytes.Equal(val, bytes.Repeat(key2, 40)) {
+		t.Errorf("Get(%v): got %v", key2, val)
+	}
+
+	it := omt.Iterator(nil)
+	for it.Next() {
+	}
+	if _, ok := it.Err().(*MissingNodeError); !ok {
+		t.Errorf("Iterator(): got %v, want missing node", it.Err())
+	}
+}
@@ -0,0 +1,131 @@
+package mptrie
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// Violation is a broken invariant found by Validate at the node with path, in nibbles.
+type Violation struct {
+	Path   []byte
+	Reason string
+}
+
+func (v Violation) String() string {
+	return fmt.Sprintf("%s: %s", nibbleString(v.Path), v.Reason)
+}
+
+type validator struct {
+	mpt        *MPTrie
+	violations []Violation
+	lastKey    nibbleKey
+}
+
+// Validate walks the trie, loading nodes from its store as needed, and returns every broken
+// invariant that it finds. An error is returned only if a node could not be loaded.
+func (mpt *MPTrie) Validate() ([]Violation, error) {
+	v := &validator{
+		mpt: mpt,
+	}
+	if mpt.root == nil {
+		return nil, nil
+	}
+
+	_, err := v.validateNode(mpt.root, nil, true)
+	if err != nil {
+		return nil, err
+	}
+	return v.violations, nil
+}
+
+func (v *validator) violation(path nibbleKey, format string, args ...interface{}) {
+	v.violations = append(v.violations, Violation{
+		Path:   append([]byte(nil), path...),
+		Reason: fmt.Sprintf(format, args...),
+	})
+}
+
+func (v *validator) validateKey(nk nibbleKey) {
+	if len(nk)%2 != 0 {
+		v.violation(nk, "key has an odd number of nibbles")
+	}
+	if v.lastKey != nil && bytes.Compare(nk, v.lastKey) <= 0 {
+		v.violation(nk, "key is not after the previous key %s", nibbleString(v.lastKey))
+	}
+	v.lastKey = nk
+}
+
+// validateNode validates n, found at path, and its children, and returns n, loading it if it
+// is a hash node.
+func (v *validator) validateNode(n node, path nibbleKey, root bool) (node, error) {
+	if hn, ok := n.(hashNode); ok {
+		var err error
+		n, err = v.mpt.resolve(hn, path)
+		if err != nil {
+			return nil, err
+		}
+
+		buf := n.encode()
+		if !bytes.Equal(keccak256(buf), hn) {
+			v.violation(path, "stored node does not match hash %x", []byte(hn))
+		} else if !root && len(buf) < 32 {
+			v.violation(path, "node of %d bytes is hashed rather than included", len(buf))
+		}
+	}
+
+	var ref []byte
+	if branch, ok := n.(*branchNode); ok {
+		ref = branch.ref
+		if branch.value != nil {
+			v.validateKey(path)
+		}
+
+		var cnt int
+		for ci, child := range branch.children {
+			if child == nil {
+				continue
+			}
+
+			cnt += 1
+			_, err := v.validateNode(child, joinNibbleKeys(path, nibbleKey{byte(ci)}), false)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		if branch.value == nil && cnt < 2 {
+			v.violation(path, "branch without a value has %d children", cnt)
+		} else if cnt == 0 {
+			v.violation(path, "branch with a value has no children")
+		}
+	} else if extension, ok := n.(*extensionNode); ok {
+		ref = extension.ref
+		if len(extension.subKey) == 0 {
+			v.violation(path, "extension has an empty key")
+		}
+
+		if extension.child == nil {
+			v.violation(path, "extension has no child")
+			return n, nil
+		}
+
+		child, err := v.validateNode(extension.child, joinNibbleKeys(path, extension.subKey),
+			false)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := child.(*branchNode); !ok {
+			v.violation(path, "extension child is not a branch: %T", child)
+		}
+	} else if leaf, ok := n.(*leafNode); ok {
+		ref = leaf.ref
+		v.validateKey(joinNibbleKeys(path, leaf.suffixKey))
+	} else {
+		panic(fmt.Sprintf("unexpected mptrie node: %#v", n))
+	}
+
+	if ref != nil && !bytes.Equal(ref, nodeRef(n.encode())) {
+		v.violation(path, "cached reference does not match node")
+	}
+	return n, nil
+}
@@ -0,0 +1,109 @@
+package mptrie
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func checkViolations(t *testing.T, mpt *MPTrie, path nibbleKey, reason string) {
+	t.Helper()
+
+	violations, err := mpt.Validate()
+	if err != nil {
+		t.Fatalf("Validate() failed with %s", err)
+	}
+	if reason == "" {
+		if len(violations) != 0 {
+			t.Errorf("Validate(): got %v, want no violations", violations)
+		}
+		return
+	}
+
+	for _, v := range violations {
+		if bytes.Equal(v.Path, path) && strings.Contains(v.Reason, reason) {
+			return
+		}
+	}
+	t.Errorf("Validate(): got %v, want %s: %s", violations, nibbleString(path), reason)
+}
+
+func TestValidate(t *testing.T) {
+	checkViolations(t, New(), nil, "")
+
+	mpt := New()
+	for i := 0; i < 500; i++ {
+		err := mpt.Put(testKey(i)[:1+i%4], testValue(i))
+		if err != nil {
+			t.Fatalf("Put(%d) failed with %s", i, err)
+		}
+	}
+	checkViolations(t, mpt, nil, "")
+
+	for i := 0; i < 500; i += 3 {
+		err := mpt.Delete(testKey(i)[:1+i%4])
+		if err != nil && err != ErrNotFound {
+			t.Fatalf("Delete(%d) failed with %s", i, err)
+		}
+	}
+	checkViolations(t, mpt, nil, "")
+
+	store := NewMemoryStore()
+	root, err := mpt.Commit(store)
+	if err != nil {
+		t.Fatalf("Commit() failed with %s", err)
+	}
+	mpt, err = Open(root, store)
+	if err != nil {
+		t.Fatalf("Open() failed with %s", err)
+	}
+	checkViolations(t, mpt, nil, "")
+
+	leaf := &leafNode{suffixKey: nibbleKey{1, 2, 3}, value: []byte("value")}
+	branch := &branchNode{}
+	branch.children[5] = leaf
+	checkViolations(t, &MPTrie{root: branch}, nil, "branch without a value has 1 children")
+
+	checkViolations(t, &MPTrie{root: &leafNode{suffixKey: nibbleKey{1, 2, 3}}},
+		nibbleKey{1, 2, 3}, "odd number of nibbles")
+
+	checkViolations(t, &MPTrie{root: &extensionNode{subKey: nibbleKey{1}, child: leaf}}, nil,
+		"extension child is not a branch")
+
+	branch = &branchNode{value: []byte("value")}
+	branch.children[2] = &leafNode{suffixKey: nibbleKey{3}, value: []byte("value")}
+	checkViolations(t, &MPTrie{root: &extensionNode{child: branch}}, nil,
+		"extension has an empty key")
+	checkViolations(t, &MPTrie{root: &extensionNode{subKey: nibbleKey{1, 2}, child: branch}},
+		nil, "")
+
+	leaf = &leafNode{suffixKey: nibbleKey{1, 2}, value: bytes.Repeat([]byte{1}, 40)}
+	leaf.hash(false)
+	leaf.value = bytes.Repeat([]byte{2}, 40)
+	checkViolations(t, &MPTrie{root: leaf}, nil, "cached reference does not match")
+
+	store = NewMemoryStore()
+	buf := leaf.encode()
+	hash := keccak256(buf)
+	hash[0] ^= 0xFF
+	err = store.Put(hash, buf)
+	if err != nil {
+		t.Fatalf("Put() failed with %s", err)
+	}
+	mpt, err = Open(hash, store)
+	if err != nil {
+		t.Fatalf("Open() failed with %s", err)
+	}
+	checkViolations(t, mpt, nil, "stored node does not match hash")
+
+	small := &leafNode{suffixKey: nibbleKey{4}, value: []byte("small")}
+	hash = keccak256(small.encode())
+	err = store.Put(hash, small.encode())
+	if err != nil {
+		t.Fatalf("Put() failed with %s", err)
+	}
+	branch = &branchNode{value: []byte("value")}
+	branch.children[7] = hashNode(hash)
+	checkViolations(t, &MPTrie{root: branch, store: store}, nibbleKey{7},
+		"node of 8 bytes is hashed")
+}